@@ -0,0 +1,83 @@
+package blx
+
+import (
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Decimal is a human readable amount such as "12.5". It is turned into base units with the
+// real decimals of the coin or token, never through float64.
+type Decimal string
+
+var decimalPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if !decimalPattern.MatchString(s) {
+		return "", AmountError
+	}
+	return Decimal(s), nil
+}
+
+// NewDecimal formats base units v with decimals, dropping trailing zeros.
+func NewDecimal(v *big.Int, decimals uint8) Decimal {
+	if v == nil {
+		return "0"
+	}
+
+	d := int(decimals)
+	digits := new(big.Int).Abs(v).String()
+	if len(digits) <= d {
+		digits = strings.Repeat("0", d-len(digits)+1) + digits
+	}
+
+	s := digits[:len(digits)-d]
+	if frac := strings.TrimRight(digits[len(digits)-d:], "0"); frac != "" {
+		s += "." + frac
+	}
+	if v.Sign() < 0 {
+		s = "-" + s
+	}
+	return Decimal(s)
+}
+
+// BaseUnits converts d to the smallest unit. Amounts with more fraction digits than decimals are
+// rejected instead of being truncated.
+func (d Decimal) BaseUnits(decimals uint8) (*big.Int, error) {
+	s, err := ParseDecimal(string(d))
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(string(s), ".", 2)
+	frac := ""
+	if len(parts) == 2 {
+		frac = strings.TrimRight(parts[1], "0")
+	}
+	if len(frac) > int(decimals) {
+		return nil, AmountError
+	}
+	frac += strings.Repeat("0", int(decimals)-len(frac))
+
+	v, ok := new(big.Int).SetString(parts[0]+frac, 10)
+	if !ok {
+		return nil, AmountError
+	}
+	return v, nil
+}
+
+func (d Decimal) String() string {
+	return string(d)
+}
+
+// decimalFromFloat 兼容旧的 float64 接口, 用最短的十进制表示代替 %f, 小数位超过 decimals 时四舍五入,
+// 像 0.1+0.2 这样的浮点误差不会让旧接口报错
+func decimalFromFloat(f float64, decimals uint8) Decimal {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if i := strings.IndexByte(s, '.'); i >= 0 && len(s)-i-1 > int(decimals) {
+		s = strconv.FormatFloat(f, 'f', int(decimals), 64)
+	}
+	return Decimal(s)
+}
@@ -0,0 +1,68 @@
+package blx
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestDecimalBaseUnits(t *testing.T) {
+	cases := []struct {
+		amount   Decimal
+		decimals uint8
+		want     string
+	}{
+		{"1", 18, "1000000000000000000"},
+		{"1.1", 18, "1100000000000000000"},
+		{"0.000000000000000001", 18, "1"},
+		{"123456789012345678.123456789012345678", 18, "123456789012345678123456789012345678"},
+		{"12.50", 2, "1250"},
+		{"7", 0, "7"},
+	}
+
+	for _, c := range cases {
+		v, err := c.amount.BaseUnits(c.decimals)
+		if err != nil {
+			t.Fatal(c.amount, err)
+		}
+		if v.String() != c.want {
+			t.Errorf("%v with %v decimals: got %v want %v", c.amount, c.decimals, v, c.want)
+		}
+	}
+
+	for _, bad := range []Decimal{"", "1.", ".5", "-1", "1e18", "0.0000001"} {
+		if _, err := bad.BaseUnits(6); err == nil {
+			t.Errorf("%q should be rejected", bad)
+		}
+	}
+}
+
+func TestNewDecimal(t *testing.T) {
+	v, _ := new(big.Int).SetString("123456789012345678123456789012345678", 10)
+	if d := NewDecimal(v, 18); d != "123456789012345678.123456789012345678" {
+		t.Error("got ", d)
+	}
+
+	if d := NewDecimal(big.NewInt(1), 18); d != "0.000000000000000001" {
+		t.Error("got ", d)
+	}
+
+	if d := NewDecimal(big.NewInt(1500), 3); d != "1.5" {
+		t.Error("got ", d)
+	}
+
+	if d := decimalFromFloat(1.1, 18); d != "1.1" {
+		t.Error("got ", d)
+	}
+
+	a, b := 0.1, 0.2
+	if d := decimalFromFloat(a+b, 6); d != "0.300000" {
+		t.Error("got ", d)
+	}
+	if v, err := decimalFromFloat(a+b, 18).BaseUnits(18); err != nil || v.String() != "300000000000000040" {
+		t.Error("got ", v, err)
+	}
+	if _, err := Decimal("0.30000000000000004").BaseUnits(6); !errors.Is(err, AmountError) {
+		t.Error("decimal input must be rejected, got ", err)
+	}
+}
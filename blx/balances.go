@@ -0,0 +1,302 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// maxMulticallCalls 一次 aggregate3 打包的调用数, 太多会超过节点 eth_call 的 gas 上限
+const maxMulticallCalls = 500
+
+const abiMulticall3 = `[
+  {"inputs":[{"components":[{"internalType":"address","name":"target","type":"address"},{"internalType":"bool","name":"allowFailure","type":"bool"},{"internalType":"bytes","name":"callData","type":"bytes"}],"internalType":"struct Multicall3.Call3[]","name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"internalType":"bool","name":"success","type":"bool"},{"internalType":"bytes","name":"returnData","type":"bytes"}],"internalType":"struct Multicall3.Result[]","name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"},
+  {"inputs":[{"internalType":"address","name":"addr","type":"address"}],"name":"getEthBalance","outputs":[{"internalType":"uint256","name":"balance","type":"uint256"}],"stateMutability":"view","type":"function"}
+]`
+
+var multicallAbi, _ = abi.JSON(strings.NewReader(abiMulticall3))
+
+// snapshotAttempts 读余额期间区块被重组换掉时最多读这么多次
+const snapshotAttempts = 3
+
+var CallFailedError = newCodedError(CodeCallFailed)
+var SnapshotChangedError = newCodedError(CodeSnapshotChanged)
+
+type multicallCall struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+type multicallResult struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// BalanceResult is the balance of one address of GetBalances or GetTokenBalances, in base
+// units. Err is set instead of Balance when only this address failed.
+type BalanceResult struct {
+	Address string
+	Balance *big.Int
+	Err     error
+}
+
+// balanceQuery 一批余额查询, token 为空时查原生币余额
+type balanceQuery struct {
+	token   *common.Address
+	block   BlockRef
+	results []BalanceResult
+	targets []common.Address
+	index   []int
+}
+
+func newBalanceQuery(token *common.Address, addresses []string) *balanceQuery {
+	q := &balanceQuery{token: token, results: make([]BalanceResult, len(addresses))}
+	for i, a := range addresses {
+		q.results[i].Address = a
+		if !common.IsHexAddress(a) {
+			q.results[i].Err = NotAnHexAddress.With("address", a)
+			continue
+		}
+		q.targets = append(q.targets, common.HexToAddress(a))
+		q.index = append(q.index, i)
+	}
+	return q
+}
+
+func (q *balanceQuery) set(k int, balance *big.Int, err error) {
+	r := &q.results[q.index[k]]
+	r.Balance, r.Err = balance, err
+	if err != nil {
+		r.Balance = nil
+	}
+}
+
+// GetBalances returns the native coin balance of addresses in the order given. The balances are
+// read with aggregated eth_call when a multicall contract is configured, see WithMulticall, and
+// with JSON-RPC batches otherwise. Invalid addresses and failed reads only set Err of their result,
+// the error is for the whole request. All balances are read at one block, the latest unless at is
+// given, pinned by number before the first request. When a reorg replaces that block meanwhile the
+// balances are read again, SnapshotChangedError is returned when it keeps changing.
+func (j *Jk) GetBalances(ctx context.Context, addresses []string, at ...BlockRef) ([]BalanceResult, error) {
+	q := newBalanceQuery(nil, addresses)
+	return q.results, j.queryBalances(ctx, q, blockOf(at))
+}
+
+// GetTokenBalances is GetBalances for the ERC-20 token contractAddr.
+func (j *Jk) GetTokenBalances(ctx context.Context, contractAddr string, addresses []string, at ...BlockRef) ([]BalanceResult, error) {
+	if contractAddr == "" {
+		return nil, ContractNotEmpty
+	}
+	if !common.IsHexAddress(contractAddr) {
+		return nil, NotAnHexAddress.With("address", contractAddr)
+	}
+	token := common.HexToAddress(contractAddr)
+	q := newBalanceQuery(&token, addresses)
+	return q.results, j.queryBalances(ctx, q, blockOf(at))
+}
+
+func (j *Jk) queryBalances(ctx context.Context, q *balanceQuery, block BlockRef) error {
+	if len(q.targets) == 0 {
+		return nil
+	}
+	//只有调用方传了哈希时才按哈希读
+	if block.hash != (common.Hash{}) || block.tag == "pending" {
+		q.block = block
+		return j.readBalances(ctx, q)
+	}
+
+	//分批查询期间可能出新块, 先固定到区块高度. 读完再核对这个高度的哈希, 被重组换掉时整批重读
+	for attempt := 1; ; attempt++ {
+		pinned, err := j.PinBlock(ctx, block)
+		if err != nil {
+			return err
+		}
+		q.block = pinned.byNumber()
+		if err = j.readBalances(ctx, q); err != nil {
+			return err
+		}
+
+		now, err := j.PinBlock(ctx, q.block)
+		if err != nil {
+			return err
+		}
+		if now.hash == pinned.hash {
+			return nil
+		}
+		log.Log.Warn("block ", q.block, " reorged while reading balances, attempt ", attempt)
+		if attempt >= snapshotAttempts {
+			return SnapshotChangedError.With("block", q.block).With("hash", pinned.hash.Hex()).With("now", now.hash.Hex())
+		}
+	}
+}
+
+// readBalances 按 chunk 分批读取 q.block 的余额
+func (j *Jk) readBalances(ctx context.Context, q *balanceQuery) error {
+	chunk := maxBatchItems
+	multicall := j.multicall()
+	if multicall != nil {
+		chunk = maxMulticallCalls
+	}
+
+	for start := 0; start < len(q.targets); start += chunk {
+		end := start + chunk
+		if end > len(q.targets) {
+			end = len(q.targets)
+		}
+
+		err := j.withClient(ctx, func(client *ethclient.Client) error {
+			if multicall != nil {
+				err := j.multicallBalances(ctx, client, *multicall, q, start, end)
+				if err == nil || ctx.Err() != nil {
+					return err
+				}
+				//合约不存在或整体失败时退回逐个查询
+				log.Log.Warn("multicall balances ", start, " - ", end, " err: ", err)
+			}
+			return j.batchBalances(ctx, client, q, start, end)
+		})
+		if err != nil {
+			log.Log.Error("get balances ", start, " - ", end, " err: ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *Jk) multicall() *common.Address {
+	if j.net.Multicall == "" || !common.IsHexAddress(j.net.Multicall) {
+		return nil
+	}
+	address := common.HexToAddress(j.net.Multicall)
+	return &address
+}
+
+// balanceInput 查询 target 余额的 eth_call 数据
+func (j *Jk) balanceInput(q *balanceQuery, target common.Address) ([]byte, error) {
+	if q.token == nil {
+		return multicallAbi.Pack("getEthBalance", target)
+	}
+	return j.tokens.ABI().Pack("balanceOf", target)
+}
+
+func (j *Jk) multicallBalances(ctx context.Context, client *ethclient.Client, multicall common.Address, q *balanceQuery, start, end int) error {
+	calls := make([]multicallCall, 0, end-start)
+	to := multicall
+	if q.token != nil {
+		to = *q.token
+	}
+	for _, target := range q.targets[start:end] {
+		input, err := j.balanceInput(q, target)
+		if err != nil {
+			return err
+		}
+		calls = append(calls, multicallCall{Target: to, AllowFailure: true, CallData: input})
+	}
+
+	input, err := multicallAbi.Pack("aggregate3", calls)
+	if err != nil {
+		return err
+	}
+	result, err := j.callContractAt(ctx, client, ethereum.CallMsg{To: &multicall, Data: input}, q.block)
+	if err != nil {
+		return err
+	}
+	out, err := multicallAbi.Unpack("aggregate3", result)
+	if err != nil {
+		return err
+	}
+	results := *abi.ConvertType(out[0], new([]multicallResult)).(*[]multicallResult)
+	if len(results) != len(calls) {
+		return errors.New("multicall returned a wrong number of results")
+	}
+
+	for k, r := range results {
+		if !r.Success {
+			q.set(start+k, nil, CallFailedError)
+			continue
+		}
+		balance, err := decodeBalance(r.ReturnData)
+		q.set(start+k, balance, err)
+	}
+	return nil
+}
+
+func (j *Jk) batchBalances(ctx context.Context, client *ethclient.Client, q *balanceQuery, start, end int) error {
+	targets := q.targets[start:end]
+	rc := j.rpcOf(client)
+	if rc == nil {
+		for k, target := range targets {
+			balance, err := j.balanceOf(ctx, client, q, target)
+			if isEndpointError(err) {
+				return err
+			}
+			q.set(start+k, balance, err)
+		}
+		return nil
+	}
+
+	raws := make([]hexutil.Bytes, len(targets))
+	balances := make([]hexutil.Big, len(targets))
+	elems := make([]rpc.BatchElem, len(targets))
+	for k, target := range targets {
+		if q.token == nil {
+			elems[k] = rpc.BatchElem{Method: "eth_getBalance", Args: []interface{}{target, q.block.arg()}, Result: &balances[k]}
+			continue
+		}
+		input, err := j.balanceInput(q, target)
+		if err != nil {
+			return err
+		}
+		call := map[string]interface{}{"to": q.token, "data": hexutil.Bytes(input)}
+		elems[k] = rpc.BatchElem{Method: "eth_call", Args: []interface{}{call, q.block.arg()}, Result: &raws[k]}
+	}
+
+	if err := rc.BatchCallContext(ctx, elems); err != nil {
+		return err
+	}
+	for k, elem := range elems {
+		switch {
+		case elem.Error != nil:
+			q.set(start+k, nil, j.asRevert(elem.Error))
+		case q.token == nil:
+			q.set(start+k, balances[k].ToInt(), nil)
+		default:
+			balance, err := decodeBalance(raws[k])
+			q.set(start+k, balance, err)
+		}
+	}
+	return nil
+}
+
+func (j *Jk) balanceOf(ctx context.Context, client *ethclient.Client, q *balanceQuery, target common.Address) (*big.Int, error) {
+	if q.token == nil {
+		return j.balanceAt(ctx, client, target, q.block)
+	}
+	input, err := j.balanceInput(q, target)
+	if err != nil {
+		return nil, err
+	}
+	result, err := j.callContractAt(ctx, client, ethereum.CallMsg{To: q.token, Data: input}, q.block)
+	if err != nil {
+		return nil, j.asRevert(err)
+	}
+	return decodeBalance(result)
+}
+
+// decodeBalance balanceOf 和 getEthBalance 都返回一个 uint256, 不是合约时返回空
+func decodeBalance(data []byte) (*big.Int, error) {
+	if len(data) != 32 {
+		return nil, CallFailedError
+	}
+	return new(big.Int).SetBytes(data), nil
+}
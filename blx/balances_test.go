@@ -0,0 +1,143 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+func TestGetBalances(t *testing.T) {
+	node := newTestNode(t)
+	token := common.HexToAddress("0xaa")
+	multicall := common.HexToAddress("0xca11")
+
+	//超过一个 batch 的地址数, 中间夹一个非法地址
+	var addresses []string
+	for i := 1; i <= 450; i++ {
+		a := common.BigToAddress(big.NewInt(int64(0x1000 + i)))
+		node.eth.setBalance(a, big.NewInt(int64(i)))
+		addresses = append(addresses, a.Hex())
+	}
+	addresses = append(addresses[:100], append([]string{"0x12"}, addresses[100:]...)...)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+	erc20 := jk.Tokens().ABI()
+	out, _ := erc20.Methods["balanceOf"].Outputs.Pack(big.NewInt(7))
+	node.eth.setMethodResult(token, erc20.Methods["balanceOf"], out)
+
+	check := func(name string, results []BalanceResult, err error) {
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if len(results) != len(addresses) {
+			t.Fatalf("%s: %d results for %d addresses", name, len(results), len(addresses))
+		}
+		want := int64(0)
+		for i, r := range results {
+			if r.Address != addresses[i] {
+				t.Fatalf("%s: result %d is for %s", name, i, r.Address)
+			}
+			if i == 100 {
+				if !errors.Is(r.Err, NotAnHexAddress) || r.Balance != nil {
+					t.Fatalf("%s: invalid address result %+v", name, r)
+				}
+				continue
+			}
+			want++
+			if r.Err != nil || r.Balance.Int64() != want {
+				t.Fatalf("%s: result %d %+v, want %d", name, i, r, want)
+			}
+		}
+	}
+
+	results, err := jk.GetBalances(context.Background(), addresses)
+	check("batch", results, err)
+
+	tokens, err := jk.GetTokenBalances(context.Background(), token.Hex(), addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range tokens {
+		if i != 100 && (r.Err != nil || r.Balance.Int64() != 7) {
+			t.Fatalf("token result %d %+v", i, r)
+		}
+	}
+	//不是合约的地址每一项都失败, 整体不报错
+	failed, err := jk.GetTokenBalances(context.Background(), common.HexToAddress("0xdead").Hex(), addresses[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range failed {
+		if !errors.Is(r.Err, CallFailedError) {
+			t.Fatalf("balance of a non contract %+v", r)
+		}
+	}
+
+	//配置了 multicall 但合约没有部署时退回 batch
+	jk2, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel), WithMulticall(multicall.Hex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk2.Close()
+	node.eth.setRevert(multicall, nil)
+	results, err = jk2.GetBalances(context.Background(), addresses)
+	check("fallback", results, err)
+
+	node.eth.mu.Lock()
+	delete(node.eth.reverts, multicall)
+	node.eth.multicall = multicall
+	node.eth.mu.Unlock()
+	aggregate3 := multicallAbi.Methods["aggregate3"]
+	before := node.eth.callCount(aggregate3)
+	results, err = jk2.GetBalances(context.Background(), addresses)
+	check("multicall", results, err)
+	if n := node.eth.callCount(aggregate3) - before; n != 1 {
+		t.Fatalf("aggregate3 called %d times for %d addresses", n, len(addresses))
+	}
+
+	failed, err = jk2.GetTokenBalances(context.Background(), token.Hex(), []string{addresses[0], addresses[1]})
+	if err != nil || failed[0].Balance.Int64() != 7 {
+		t.Fatalf("%+v %v", failed, err)
+	}
+	node.eth.setRevert(token, nil)
+	failed, err = jk2.GetTokenBalances(context.Background(), token.Hex(), []string{addresses[0]})
+	if err != nil || !errors.Is(failed[0].Err, CallFailedError) {
+		t.Fatal("reverted balanceOf in multicall ", failed, err)
+	}
+}
+
+func TestGetBalancesReorgWhileReading(t *testing.T) {
+	node := newTestNode(t)
+	node.eth.mu.Lock()
+	node.eth.reorgOnBalance = 1
+	node.eth.mu.Unlock()
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	addresses := []string{common.HexToAddress("0xa1").Hex()}
+	//第一次读取期间区块被换掉, 重读一次
+	results, err := jk.GetBalances(context.Background(), addresses)
+	if err != nil || results[0].Err != nil || results[0].Balance.Cmp(big.NewInt(1e18)) != 0 {
+		t.Fatalf("results %+v err %v", results, err)
+	}
+
+	//一直在重组时报错, 不返回混合了多个区块的结果
+	node.eth.mu.Lock()
+	node.eth.reorgOnBalance = 10
+	node.eth.mu.Unlock()
+	if _, err := jk.GetBalances(context.Background(), addresses); !errors.Is(err, SnapshotChangedError) {
+		t.Fatalf("err %v, want SnapshotChangedError", err)
+	}
+}
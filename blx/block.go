@@ -0,0 +1,239 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// BlockRef selects the state a read API sees: a block number, a block hash or one of the tags
+// latest, pending and finalized. The zero value is latest. Reads at a hash fail when the block is
+// no longer on the canonical chain.
+type BlockRef struct {
+	tag    string
+	number *big.Int
+	hash   common.Hash
+}
+
+var (
+	LatestBlock    = BlockRef{tag: "latest"}
+	PendingBlock   = BlockRef{tag: "pending"}
+	FinalizedBlock = BlockRef{tag: "finalized"}
+)
+
+func BlockAt(number uint64) BlockRef {
+	return BlockRef{number: new(big.Int).SetUint64(number)}
+}
+
+func BlockWithHash(hash common.Hash) BlockRef {
+	return BlockRef{hash: hash}
+}
+
+// ParseBlockRef parses a tag, a decimal or 0x number, or a 0x block hash.
+func ParseBlockRef(s string) (BlockRef, error) {
+	switch s {
+	case "", "latest":
+		return LatestBlock, nil
+	case "pending":
+		return PendingBlock, nil
+	case "finalized":
+		return FinalizedBlock, nil
+	}
+	if strings.HasPrefix(s, "0x") && len(s) == 2+2*common.HashLength {
+		hash, err := hexutil.Decode(s)
+		if err != nil {
+			return BlockRef{}, err
+		}
+		return BlockWithHash(common.BytesToHash(hash)), nil
+	}
+	number, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return BlockRef{}, fmt.Errorf("invalid block %q", s)
+	}
+	return BlockAt(number), nil
+}
+
+func (r BlockRef) String() string {
+	switch {
+	case r.hash != (common.Hash{}):
+		return r.hash.Hex()
+	case r.number != nil:
+		return r.number.String()
+	case r.tag != "":
+		return r.tag
+	default:
+		return "latest"
+	}
+}
+
+// Hash is set for refs made by BlockWithHash and PinBlock.
+func (r BlockRef) Hash() common.Hash {
+	return r.hash
+}
+
+// Number is set for refs made by BlockAt and PinBlock, nil for tags.
+func (r BlockRef) Number() *big.Int {
+	if r.number == nil {
+		return nil
+	}
+	return new(big.Int).Set(r.number)
+}
+
+// blockOf 可变参数里的区块, 没有传时是 latest
+func blockOf(at []BlockRef) BlockRef {
+	if len(at) == 0 {
+		return LatestBlock
+	}
+	return at[0]
+}
+
+// clientNumber ethclient 能直接表示的区块, latest 为 nil
+func (r BlockRef) clientNumber() (*big.Int, bool) {
+	switch {
+	case r.hash != (common.Hash{}):
+		return nil, false
+	case r.number != nil:
+		return r.number, true
+	default:
+		return nil, r.tag == "" || r.tag == "latest"
+	}
+}
+
+// arg 区块参数, 哈希按 EIP-1898 传
+func (r BlockRef) arg() interface{} {
+	switch {
+	case r.hash != (common.Hash{}):
+		return map[string]interface{}{"blockHash": r.hash, "requireCanonical": true}
+	case r.number != nil:
+		return hexutil.EncodeBig(r.number)
+	case r.tag != "":
+		return r.tag
+	default:
+		return "latest"
+	}
+}
+
+// PinBlock resolves latest, finalized and numbers to the number and the node reported hash of
+// the block they point at now, so several reads with the result see one consistent state even
+// while new blocks arrive. Reads with the result go by hash, which needs EIP-1898 support of the
+// node. pending can not be pinned and is returned as is.
+func (j *Jk) PinBlock(ctx context.Context, ref BlockRef) (BlockRef, error) {
+	if ref.hash != (common.Hash{}) || ref.tag == "pending" {
+		return ref, nil
+	}
+
+	var pinned BlockRef
+	err := j.withClient(ctx, func(client *ethclient.Client) (err error) {
+		pinned, err = j.resolveBlock(ctx, client, ref)
+		return
+	})
+	if err != nil {
+		log.Log.Error("pin block ", ref, " err: ", err)
+		return ref, err
+	}
+	return pinned, nil
+}
+
+// resolveBlock 查 ref 当前指向的区块高度和节点返回的哈希, 非 geth 格式的链上本地算的 Hash() 节点不认识
+func (j *Jk) resolveBlock(ctx context.Context, client *ethclient.Client, ref BlockRef) (BlockRef, error) {
+	rc := j.rpcOf(client)
+	if rc == nil {
+		number, ok := ref.clientNumber()
+		if !ok {
+			return ref, errors.New("no rpc connection")
+		}
+		header, err := client.HeaderByNumber(ctx, number)
+		if err != nil {
+			return ref, err
+		}
+		return BlockRef{number: header.Number, hash: header.Hash()}, nil
+	}
+
+	var head *struct {
+		Number *hexutil.Big `json:"number"`
+		Hash   common.Hash  `json:"hash"`
+	}
+	if err := rc.CallContext(ctx, &head, "eth_getBlockByNumber", ref.arg(), false); err != nil {
+		return ref, err
+	}
+	if head == nil || head.Number == nil {
+		return ref, ethereum.NotFound
+	}
+	return BlockRef{number: head.Number.ToInt(), hash: head.Hash}, nil
+}
+
+// byNumber 去掉哈希只按高度读, 不支持 EIP-1898 的节点也能用
+func (r BlockRef) byNumber() BlockRef {
+	if r.number == nil {
+		return r
+	}
+	return BlockRef{number: r.number}
+}
+
+// balanceAt 按区块读余额, ethclient 不支持的区块走原始 rpc
+func (j *Jk) balanceAt(ctx context.Context, client *ethclient.Client, account common.Address, ref BlockRef) (*big.Int, error) {
+	if number, ok := ref.clientNumber(); ok {
+		return client.BalanceAt(ctx, account, number)
+	}
+	var result hexutil.Big
+	if err := j.rawCall(ctx, client, &result, "eth_getBalance", account, ref.arg()); err != nil {
+		return nil, err
+	}
+	return (*big.Int)(&result), nil
+}
+
+func (j *Jk) codeAt(ctx context.Context, client *ethclient.Client, account common.Address, ref BlockRef) ([]byte, error) {
+	if number, ok := ref.clientNumber(); ok {
+		return client.CodeAt(ctx, account, number)
+	}
+	var result hexutil.Bytes
+	err := j.rawCall(ctx, client, &result, "eth_getCode", account, ref.arg())
+	return result, err
+}
+
+func (j *Jk) callContractAt(ctx context.Context, client *ethclient.Client, msg ethereum.CallMsg, ref BlockRef) ([]byte, error) {
+	if number, ok := ref.clientNumber(); ok {
+		return client.CallContract(ctx, msg, number)
+	}
+	var result hexutil.Bytes
+	err := j.rawCall(ctx, client, &result, "eth_call", callArg(msg), ref.arg())
+	return result, err
+}
+
+func (j *Jk) rawCall(ctx context.Context, client *ethclient.Client, result interface{}, method string, args ...interface{}) error {
+	rc := j.rpcOf(client)
+	if rc == nil {
+		return errors.New("no rpc connection")
+	}
+	return rc.CallContext(ctx, result, method, args...)
+}
+
+// callArg 和 ethclient 一样的 eth_call 参数
+func callArg(msg ethereum.CallMsg) interface{} {
+	arg := map[string]interface{}{
+		"from": msg.From,
+		"to":   msg.To,
+	}
+	if len(msg.Data) > 0 {
+		arg["data"] = hexutil.Bytes(msg.Data)
+	}
+	if msg.Value != nil {
+		arg["value"] = (*hexutil.Big)(msg.Value)
+	}
+	if msg.Gas != 0 {
+		arg["gas"] = hexutil.Uint64(msg.Gas)
+	}
+	if msg.GasPrice != nil {
+		arg["gasPrice"] = (*hexutil.Big)(msg.GasPrice)
+	}
+	return arg
+}
@@ -0,0 +1,145 @@
+package blx
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseBlockRef(t *testing.T) {
+	hash := common.HexToHash("0x01")
+	cases := map[string]string{
+		"":             "latest",
+		"pending":      "pending",
+		"finalized":    "finalized",
+		"100":          "100",
+		"0x64":         "100",
+		hash.Hex():     hash.Hex(),
+		"0x" + "zz":    "",
+		"not a number": "",
+	}
+	for in, want := range cases {
+		ref, err := ParseBlockRef(in)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%q parsed as %s", in, ref)
+			}
+			continue
+		}
+		if err != nil || ref.String() != want {
+			t.Errorf("%q parsed as %s %v, want %s", in, ref, err, want)
+		}
+	}
+}
+
+func TestReadAtBlock(t *testing.T) {
+	node := newTestNode(t)
+	node.eth.buildChain(1, 20, 0)
+	alice := common.HexToAddress("0xa1")
+	token := common.HexToAddress("0xaa")
+	node.eth.setBalanceAt(alice, 10, big.NewInt(5))
+	node.eth.setCode(token, []byte{0x60, 0x80})
+	hash10 := node.eth.headers[10].Hash()
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+	ctx := context.Background()
+
+	for _, ref := range []BlockRef{BlockAt(10), BlockWithHash(hash10)} {
+		balance, err := jk.GetBalanceOfBig(ctx, alice.Hex(), ref)
+		if err != nil || balance.Int64() != 5 {
+			t.Fatal("balance at ", ref, ": ", balance, err)
+		}
+	}
+	for _, ref := range []BlockRef{LatestBlock, FinalizedBlock, PendingBlock} {
+		balance, err := jk.GetBalanceOfBig(ctx, alice.Hex(), ref)
+		if err != nil || balance.Cmp(big.NewInt(1e18)) != 0 {
+			t.Fatal("balance at ", ref, ": ", balance, err)
+		}
+	}
+	if _, err = jk.GetBalanceOfBig(ctx, alice.Hex(), BlockWithHash(common.HexToHash("0x01"))); err == nil {
+		t.Fatal("read at an unknown block hash")
+	}
+
+	if ok, err := jk.IsContract(ctx, token.Hex(), BlockWithHash(hash10)); err != nil || !ok {
+		t.Fatal("is contract ", ok, err)
+	}
+	if ok, err := jk.IsContract(ctx, alice.Hex()); err != nil || ok {
+		t.Fatal("is contract ", ok, err)
+	}
+
+	pinned, err := jk.PinBlock(ctx, LatestBlock)
+	if err != nil || pinned.Hash() != node.eth.headers[20].Hash() || pinned.Number().Uint64() != 20 {
+		t.Fatal("pinned ", pinned, err)
+	}
+
+	//多个地址的快照分批读取时都在同一个区块高度上
+	addresses := make([]string, 250)
+	for i := range addresses {
+		addresses[i] = common.BigToAddress(big.NewInt(int64(0x1000 + i))).Hex()
+	}
+	check := func(ref BlockRef, want func(arg fakeBlock) bool) {
+		node.eth.mu.Lock()
+		node.eth.blocks = nil
+		node.eth.mu.Unlock()
+		results, err := jk.GetBalances(ctx, addresses, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range results {
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+		}
+		args := node.eth.blockArgs()
+		if len(args) != len(addresses) {
+			t.Fatalf("%d balance reads for %d addresses", len(args), len(addresses))
+		}
+		for _, arg := range args {
+			if !want(arg) {
+				t.Fatalf("balance at %s read at %+v", ref, arg)
+			}
+		}
+	}
+	check(LatestBlock, func(arg fakeBlock) bool { return arg.hash == nil && arg.tag == "0x14" })
+	//调用方传了哈希才按哈希读
+	check(BlockWithHash(hash10), func(arg fakeBlock) bool { return arg.hash != nil && *arg.hash == hash10 })
+}
+
+// 节点的区块哈希不是 geth 格式, 也不支持 EIP-1898
+func TestReadAtBlockForeignNode(t *testing.T) {
+	node := newTestNode(t)
+	node.eth.foreignHashes = true
+	node.eth.buildChain(1, 20, 0)
+	alice := common.HexToAddress("0xa1")
+	node.eth.setBalanceAt(alice, 20, big.NewInt(5))
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+	ctx := context.Background()
+
+	pinned, err := jk.PinBlock(ctx, LatestBlock)
+	if err != nil || pinned.Hash() != node.eth.hashOf(20) || pinned.Number().Uint64() != 20 {
+		t.Fatal("pinned ", pinned, err)
+	}
+	if balance, err := jk.GetBalanceOfBig(ctx, alice.Hex(), pinned); err != nil || balance.Int64() != 5 {
+		t.Fatal("balance at the pinned hash ", balance, err)
+	}
+
+	node.eth.mu.Lock()
+	node.eth.noBlockHash = true
+	node.eth.mu.Unlock()
+	results, err := jk.GetBalances(ctx, []string{alice.Hex()})
+	if err != nil || results[0].Err != nil || results[0].Balance.Int64() != 5 {
+		t.Fatalf("balances without EIP-1898 %+v %v", results, err)
+	}
+}
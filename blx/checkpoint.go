@@ -0,0 +1,205 @@
+package blx
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+var NamespaceError = newCodedError(CodeNamespace)
+
+// Checkpoint is the progress of one scanner, Height is the last block fully handled and
+// Recent the latest handled blocks, oldest first.
+type Checkpoint struct {
+	Height uint64         `json:"height"`
+	Recent []ScannedBlock `json:"recent,omitempty"`
+}
+
+// CheckpointStore keeps scanner progress across restarts. Every scanner uses its own
+// namespace, so several scanners can share one store.
+type CheckpointStore interface {
+	// Load returns nil without error when the namespace has no checkpoint yet.
+	Load(namespace string) (*Checkpoint, error)
+	Save(namespace string, cp *Checkpoint) error
+	Close() error
+}
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func checkNamespace(namespace string) error {
+	if !namespacePattern.MatchString(namespace) || namespace == "." || namespace == ".." {
+		return NamespaceError
+	}
+	return nil
+}
+
+// FileCheckpointStore keeps one json file per namespace in dir. Writes go to a temp file
+// that is renamed over the old one, so a crash never leaves a half written checkpoint.
+type FileCheckpointStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileCheckpointStore(dir string) (*FileCheckpointStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileCheckpointStore{dir: dir}, nil
+}
+
+func (s *FileCheckpointStore) path(namespace string) string {
+	return filepath.Join(s.dir, namespace+".checkpoint")
+}
+
+func (s *FileCheckpointStore) Load(namespace string) (*Checkpoint, error) {
+	if err := checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := ioutil.ReadFile(s.path(namespace))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp Checkpoint
+	err = json.Unmarshal(data, &cp)
+	if err != nil {
+		log.Log.Error("decode checkpoint ", namespace, " err: ", err)
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (s *FileCheckpointStore) Save(namespace string, cp *Checkpoint) error {
+	if err := checkNamespace(namespace); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := ioutil.TempFile(s.dir, namespace+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Log.Error("write checkpoint ", namespace, " err: ", err)
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(namespace))
+}
+
+func (s *FileCheckpointStore) Close() error {
+	return nil
+}
+
+// MemoryCheckpointStore keeps checkpoints in memory, for tests and throwaway scanners.
+type MemoryCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[string]Checkpoint
+}
+
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]Checkpoint)}
+}
+
+func (s *MemoryCheckpointStore) Load(namespace string) (*Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp, ok := s.checkpoints[namespace]
+	if !ok {
+		return nil, nil
+	}
+	return &cp, nil
+}
+
+func (s *MemoryCheckpointStore) Save(namespace string, cp *Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *cp
+	c.Recent = append([]ScannedBlock(nil), cp.Recent...)
+	s.checkpoints[namespace] = c
+	return nil
+}
+
+func (s *MemoryCheckpointStore) Close() error {
+	return nil
+}
+
+// LevelDBCheckpointStore keeps checkpoints in an embedded leveldb at path.
+type LevelDBCheckpointStore struct {
+	db *leveldb.DB
+}
+
+func NewLevelDBCheckpointStore(path string) (*LevelDBCheckpointStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDBCheckpointStore{db: db}, nil
+}
+
+func checkpointKey(namespace string) []byte {
+	return []byte("checkpoint/" + namespace)
+}
+
+func (s *LevelDBCheckpointStore) Load(namespace string) (*Checkpoint, error) {
+	data, err := s.db.Get(checkpointKey(namespace), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp Checkpoint
+	err = json.Unmarshal(data, &cp)
+	if err != nil {
+		log.Log.Error("decode checkpoint ", namespace, " err: ", err)
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (s *LevelDBCheckpointStore) Save(namespace string, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(checkpointKey(namespace), data, &opt.WriteOptions{Sync: true})
+}
+
+func (s *LevelDBCheckpointStore) Close() error {
+	return s.db.Close()
+}
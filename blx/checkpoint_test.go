@@ -0,0 +1,94 @@
+package blx
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+func testCheckpointStore(t *testing.T, store CheckpointStore) {
+	cp, err := store.Load("deposits")
+	if err != nil || cp != nil {
+		t.Fatalf("empty store returned %v, %v", cp, err)
+	}
+
+	//先写长的再写短的, 不能留下旧的数字
+	for _, h := range []uint64{123456, 99} {
+		err = store.Save("deposits", &Checkpoint{Height: h})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cp, err = store.Load("deposits")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cp == nil || cp.Height != h {
+			t.Fatalf("loaded %v, want %v", cp, h)
+		}
+	}
+
+	err = store.Save("withdrawals", &Checkpoint{Height: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err = store.Load("deposits")
+	if err != nil || cp.Height != 99 {
+		t.Fatalf("namespaces share state: %v, %v", cp, err)
+	}
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	log.Init(logrus.ErrorLevel)
+
+	dir := t.TempDir()
+	store, err := NewFileCheckpointStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCheckpointStore(t, store)
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(matches) != 0 {
+		t.Fatalf("temp files left behind: %v", matches)
+	}
+
+	if err := store.Save("../escape", &Checkpoint{Height: 1}); err != NamespaceError {
+		t.Fatalf("bad namespace accepted: %v", err)
+	}
+
+	reopened, _ := NewFileCheckpointStore(dir)
+	cp, err := reopened.Load("withdrawals")
+	if err != nil || cp == nil || cp.Height != 7 {
+		t.Fatalf("checkpoint not persisted: %v, %v", cp, err)
+	}
+}
+
+func TestMemoryCheckpointStore(t *testing.T) {
+	testCheckpointStore(t, NewMemoryCheckpointStore())
+}
+
+func TestLevelDBCheckpointStore(t *testing.T) {
+	log.Init(logrus.ErrorLevel)
+
+	path := filepath.Join(t.TempDir(), "checkpoints")
+	store, err := NewLevelDBCheckpointStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCheckpointStore(t, store)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewLevelDBCheckpointStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	cp, err := reopened.Load("withdrawals")
+	if err != nil || cp == nil || cp.Height != 7 {
+		t.Fatalf("checkpoint not persisted: %v, %v", cp, err)
+	}
+}
@@ -0,0 +1,93 @@
+package blx
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// Contract calls and transacts the methods of any ABI by name. The custom errors of the ABI are
+// used to decode reverts.
+type Contract struct {
+	jk      *Jk
+	address common.Address
+	abi     abi.ABI
+}
+
+// BindContract binds address with the contract ABI in abiJSON.
+func (j *Jk) BindContract(address string, abiJSON string) (*Contract, error) {
+	if !common.IsHexAddress(address) {
+		return nil, NotAnHexAddress.With("address", address)
+	}
+
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, err
+	}
+	return &Contract{jk: j, address: common.HexToAddress(address), abi: parsed}, nil
+}
+
+func (c *Contract) Address() common.Address {
+	return c.address
+}
+
+func (c *Contract) ABI() abi.ABI {
+	return c.abi
+}
+
+// Call runs a read only method on the latest block and returns its decoded outputs.
+func (c *Contract) Call(ctx context.Context, method string, args ...interface{}) ([]interface{}, error) {
+	return c.CallAt(ctx, LatestBlock, method, args...)
+}
+
+// CallAt is Call on the state of block.
+func (c *Contract) CallAt(ctx context.Context, block BlockRef, method string, args ...interface{}) ([]interface{}, error) {
+	input, err := c.abi.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []byte
+	err = c.jk.withClient(ctx, func(client *ethclient.Client) (err error) {
+		result, err = c.jk.callContractAt(ctx, client, ethereum.CallMsg{To: &c.address, Data: input}, block)
+		return
+	})
+	if err != nil {
+		log.Log.Error("call ", method, " of ", c.address.Hex(), " err: ", err)
+		return nil, c.jk.asRevert(err, c.abi)
+	}
+
+	return c.abi.Unpack(method, result)
+}
+
+// Transact sends method with value base units of the native coin and waits for the receipt, like
+// the sync send APIs. value may be nil.
+func (c *Contract) Transact(ctx context.Context, signer Signer, method string, value *big.Int, args ...interface{}) (hash string, err error) {
+	return c.transact(ctx, signer, method, value, true, args)
+}
+
+// TransactAsync is Transact without waiting for the receipt.
+func (c *Contract) TransactAsync(ctx context.Context, signer Signer, method string, value *big.Int, args ...interface{}) (hash string, err error) {
+	return c.transact(ctx, signer, method, value, false, args)
+}
+
+func (c *Contract) transact(ctx context.Context, signer Signer, method string, value *big.Int, wait bool, args []interface{}) (string, error) {
+	if value == nil {
+		value = big.NewInt(0)
+	}
+	if value.Sign() < 0 {
+		return "", AmountError.With("value", value)
+	}
+
+	input, err := c.abi.Pack(method, args...)
+	if err != nil {
+		return "", err
+	}
+	return c.jk.sendInput(ctx, signer, txRequest{to: c.address, value: value, input: input, errorABIs: []abi.ABI{c.abi}}, wait)
+}
@@ -0,0 +1,87 @@
+package blx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+const testRegistryABI = `[
+	{"type":"function","name":"get","stateMutability":"view","inputs":[{"name":"key","type":"uint256"}],"outputs":[{"name":"value","type":"uint256"},{"name":"label","type":"string"}]},
+	{"type":"function","name":"set","stateMutability":"payable","inputs":[{"name":"key","type":"uint256"}],"outputs":[]},
+	{"type":"error","name":"Denied","inputs":[{"name":"who","type":"address"}]}
+]`
+
+func TestContractCallAndTransact(t *testing.T) {
+	node := newTestNode(t)
+	address := common.HexToAddress("0xc2")
+	parsed, err := abi.JSON(strings.NewReader(testRegistryABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := parsed.Methods["get"].Outputs.Pack(big.NewInt(42), "answer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.eth.setCallResult(address, output)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel), WithLegacyTx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	if _, err := jk.BindContract("0xnot", testRegistryABI); !errors.Is(err, NotAnHexAddress) {
+		t.Fatalf("err %v, want NotAnHexAddress", err)
+	}
+	c, err := jk.BindContract(address.Hex(), testRegistryABI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := c.Call(context.Background(), "get", big.NewInt(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].(*big.Int).Int64() != 42 || out[1].(string) != "answer" {
+		t.Fatalf("unexpected outputs %v", out)
+	}
+
+	key, _ := crypto.GenerateKey()
+	signer := NewKeySigner(key)
+	hash, err := c.Transact(context.Background(), signer, "set", big.NewInt(5), big.NewInt(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(node.eth.sent) != 1 {
+		t.Fatalf("sent %d transactions, want 1", len(node.eth.sent))
+	}
+	tx := node.eth.sent[0]
+	input, _ := parsed.Pack("set", big.NewInt(7))
+	if tx.Hash().Hex() != hash || *tx.To() != address || tx.Value().Int64() != 5 || !bytes.Equal(tx.Data(), input) {
+		t.Fatalf("unexpected transaction %s to %s value %v", tx.Hash().Hex(), tx.To().Hex(), tx.Value())
+	}
+
+	//合约自己的自定义错误不需要 WithErrorABI
+	who := signer.Address()
+	denied := parsed.Errors["Denied"]
+	data, _ := denied.Inputs.Pack(who)
+	node.eth.setRevert(address, append(append([]byte{}, denied.ID[:4]...), data...))
+
+	_, err = c.Transact(context.Background(), signer, "set", nil, big.NewInt(7))
+	var revert *RevertError
+	if !errors.As(err, &revert) || revert.ErrorName != "Denied" || revert.ErrorArgs[0].(common.Address) != who {
+		t.Fatalf("unexpected transact err %v", err)
+	}
+	if _, err = c.Call(context.Background(), "get", big.NewInt(7)); !errors.As(err, &revert) || revert.ErrorName != "Denied" {
+		t.Fatalf("unexpected call err %v", err)
+	}
+}
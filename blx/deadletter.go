@@ -0,0 +1,457 @@
+package blx
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	ldbutil "github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// maxReplayBackoff 重放间隔翻倍的上限
+const maxReplayBackoff = time.Hour
+
+// FailedTx is a transaction the scan handler failed on. Attempts counts the failed runs,
+// the scan included.
+type FailedTx struct {
+	Namespace   string      `json:"namespace"`
+	TxHash      common.Hash `json:"txHash"`
+	Block       uint64      `json:"block"`
+	BlockHash   common.Hash `json:"blockHash"`
+	Error       string      `json:"error"`
+	Attempts    int         `json:"attempts"`
+	FirstFailed time.Time   `json:"firstFailed"`
+	LastFailed  time.Time   `json:"lastFailed"`
+}
+
+// DeadLetterStore keeps the failed transactions of scanners by namespace and tx hash.
+type DeadLetterStore interface {
+	Put(f *FailedTx) error
+	// List returns the records of namespace, oldest failure first.
+	List(namespace string) ([]*FailedTx, error)
+	Delete(namespace string, txHash common.Hash) error
+	Close() error
+}
+
+type deadLetterKey struct {
+	namespace string
+	hash      common.Hash
+}
+
+func sortFailed(list []*FailedTx) {
+	sort.SliceStable(list, func(a, b int) bool {
+		return list[a].FirstFailed.Before(list[b].FirstFailed)
+	})
+}
+
+// MemoryDeadLetterStore keeps failed transactions in memory, for tests and throwaway scanners.
+type MemoryDeadLetterStore struct {
+	mu      sync.Mutex
+	records map[deadLetterKey]FailedTx
+}
+
+func NewMemoryDeadLetterStore() *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{records: make(map[deadLetterKey]FailedTx)}
+}
+
+func (s *MemoryDeadLetterStore) Put(f *FailedTx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[deadLetterKey{f.Namespace, f.TxHash}] = *f
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) List(namespace string) ([]*FailedTx, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []*FailedTx
+	for k, f := range s.records {
+		if k.namespace == namespace {
+			f := f
+			list = append(list, &f)
+		}
+	}
+	sortFailed(list)
+	return list, nil
+}
+
+func (s *MemoryDeadLetterStore) Delete(namespace string, txHash common.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, deadLetterKey{namespace, txHash})
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) Close() error {
+	return nil
+}
+
+// deadLetterLine 文件里的一行, 后面的行覆盖前面的, Deleted 表示已经处理掉
+type deadLetterLine struct {
+	*FailedTx
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// FileDeadLetterStore appends one json line per change to a file and keeps the records in
+// memory. The file is compacted when it is opened.
+type FileDeadLetterStore struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	records map[deadLetterKey]FailedTx
+}
+
+func NewFileDeadLetterStore(path string) (*FileDeadLetterStore, error) {
+	s := &FileDeadLetterStore{path: path, records: make(map[deadLetterKey]FailedTx)}
+
+	lines, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if lines > len(s.records) {
+		if err = s.compact(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileDeadLetterStore) load() (int, error) {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		lines++
+
+		var line deadLetterLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil || line.FailedTx == nil {
+			//崩溃时可能写了半行, 跳过
+			log.Log.Warn("skip bad dead letter line ", lines, " in ", s.path, ": ", err)
+			continue
+		}
+		key := deadLetterKey{line.Namespace, line.TxHash}
+		if line.Deleted {
+			delete(s.records, key)
+		} else {
+			s.records[key] = *line.FailedTx
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// compact 只留下现存的记录, 写临时文件再改名
+func (s *FileDeadLetterStore) compact() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, f := range s.records {
+		f := f
+		data, err := json.Marshal(deadLetterLine{FailedTx: &f})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(append(data, '\n'))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Log.Error("compact dead letters ", s.path, " err: ", err)
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileDeadLetterStore) append(line deadLetterLine) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(data, '\n'))
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		log.Log.Error("write dead letter ", s.path, " err: ", err)
+	}
+	return err
+}
+
+func (s *FileDeadLetterStore) Put(f *FailedTx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(deadLetterLine{FailedTx: f}); err != nil {
+		return err
+	}
+	s.records[deadLetterKey{f.Namespace, f.TxHash}] = *f
+	return nil
+}
+
+func (s *FileDeadLetterStore) List(namespace string) ([]*FailedTx, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []*FailedTx
+	for k, f := range s.records {
+		if k.namespace == namespace {
+			f := f
+			list = append(list, &f)
+		}
+	}
+	sortFailed(list)
+	return list, nil
+}
+
+func (s *FileDeadLetterStore) Delete(namespace string, txHash common.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := deadLetterKey{namespace, txHash}
+	if _, ok := s.records[key]; !ok {
+		return nil
+	}
+	if err := s.append(deadLetterLine{FailedTx: &FailedTx{Namespace: namespace, TxHash: txHash}, Deleted: true}); err != nil {
+		return err
+	}
+	delete(s.records, key)
+	return nil
+}
+
+func (s *FileDeadLetterStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// LevelDBDeadLetterStore keeps failed transactions in an embedded leveldb at path.
+type LevelDBDeadLetterStore struct {
+	db *leveldb.DB
+}
+
+func NewLevelDBDeadLetterStore(path string) (*LevelDBDeadLetterStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDBDeadLetterStore{db: db}, nil
+}
+
+func deadLetterPrefix(namespace string) []byte {
+	return []byte("deadletter/" + namespace + "/")
+}
+
+func (s *LevelDBDeadLetterStore) Put(f *FailedTx) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(append(deadLetterPrefix(f.Namespace), f.TxHash.Bytes()...), data, &opt.WriteOptions{Sync: true})
+}
+
+func (s *LevelDBDeadLetterStore) List(namespace string) ([]*FailedTx, error) {
+	it := s.db.NewIterator(ldbutil.BytesPrefix(deadLetterPrefix(namespace)), nil)
+	defer it.Release()
+
+	var list []*FailedTx
+	for it.Next() {
+		var f FailedTx
+		if err := json.Unmarshal(it.Value(), &f); err != nil {
+			log.Log.Error("decode dead letter ", namespace, " err: ", err)
+			return nil, err
+		}
+		list = append(list, &f)
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	sortFailed(list)
+	return list, nil
+}
+
+func (s *LevelDBDeadLetterStore) Delete(namespace string, txHash common.Hash) error {
+	return s.db.Delete(append(deadLetterPrefix(namespace), txHash.Bytes()...), &opt.WriteOptions{Sync: true})
+}
+
+func (s *LevelDBDeadLetterStore) Close() error {
+	return s.db.Close()
+}
+
+// deadLetter 记录扫块时处理失败的交易, 没有配置 store 时照旧写 errtx.info
+func (s *Scanner) deadLetter(tx *types.Transaction, block *types.Block, cause error) error {
+	if s.opts.deadLetters == nil {
+		if err := s.jk.writeErrorTx(tx, block); err != nil {
+			log.Log.Error("write tx err info: ", tx, " err happened ", "record block and to next")
+		}
+		return nil
+	}
+
+	//重新扫到同一笔交易时接着之前的记录累加, 不重置重试次数和退避
+	f, err := s.findDeadLetter(tx.Hash())
+	if err != nil {
+		log.Log.Error("load dead letter ", tx.Hash().Hex(), " err: ", err)
+		return err
+	}
+	now := time.Now()
+	if f == nil {
+		f = &FailedTx{Namespace: s.namespace, TxHash: tx.Hash(), FirstFailed: now}
+	}
+	f.Block = block.NumberU64()
+	f.BlockHash = block.Hash()
+	f.Error = cause.Error()
+	f.Attempts++
+	f.LastFailed = now
+
+	err = s.opts.deadLetters.Put(f)
+	if err != nil {
+		log.Log.Error("put dead letter ", tx.Hash().Hex(), " err: ", err)
+	}
+	return err
+}
+
+func (s *Scanner) findDeadLetter(txHash common.Hash) (*FailedTx, error) {
+	list, err := s.opts.deadLetters.List(s.namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range list {
+		if f.TxHash == txHash {
+			return f, nil
+		}
+	}
+	return nil, nil
+}
+
+// ReplayFailed runs handle again on the dead letters of the scanner that are due, nil uses the
+// scanner's own handler. A record is retried after the replay backoff, doubled on every failure,
+// and kept without retry once it failed the replay attempts. Records whose block was reorged out
+// are dropped, the scanner sees their transactions again.
+func (s *Scanner) ReplayFailed(ctx context.Context, handle ReceiptHandler) error {
+	store := s.opts.deadLetters
+	if store == nil {
+		return NoDeadLetterStoreError
+	}
+	if handle == nil {
+		handle = s.handle
+	}
+
+	list, err := store.List(s.namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range list {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if f.Attempts >= s.opts.replayAttempts || time.Now().Before(f.LastFailed.Add(s.replayBackoff(f.Attempts))) {
+			continue
+		}
+
+		err = s.jk.withClient(ctx, func(client *ethclient.Client) error {
+			return s.replay(ctx, client, f, handle)
+		})
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		//取不到数据的记录留到下次, 不影响后面的记录
+		if err != nil {
+			log.Log.Error("replay dead letter ", f.TxHash.Hex(), " err: ", err)
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) replayBackoff(attempts int) time.Duration {
+	backoff := s.opts.replayBackoff << uint(attempts-1)
+	if backoff > maxReplayBackoff || backoff <= 0 {
+		backoff = maxReplayBackoff
+	}
+	return backoff
+}
+
+// replay 只在取数据出错时返回错误, handler 的错误记到记录里
+func (s *Scanner) replay(ctx context.Context, client *ethclient.Client, f *FailedTx, handle ReceiptHandler) error {
+	store := s.opts.deadLetters
+
+	block, err := client.BlockByNumber(ctx, new(big.Int).SetUint64(f.Block))
+	if err != nil {
+		log.Log.Error("replay get block height: ", f.Block, " err: ", err)
+		return err
+	}
+	if block.Hash() != f.BlockHash {
+		log.Log.Warn("drop dead letter ", f.TxHash.Hex(), ", block ", f.Block, " was reorged")
+		return store.Delete(f.Namespace, f.TxHash)
+	}
+
+	tx := block.Transaction(f.TxHash)
+	if tx == nil {
+		log.Log.Warn("drop dead letter ", f.TxHash.Hex(), ", not in block ", f.Block)
+		return store.Delete(f.Namespace, f.TxHash)
+	}
+
+	var receipt *types.Receipt
+	var logs []DecodedLog
+	if s.opts.receipts {
+		receipt, err = client.TransactionReceipt(ctx, f.TxHash)
+		if err != nil {
+			log.Log.Error("replay get receipt ", f.TxHash.Hex(), " err: ", err)
+			return err
+		}
+		logs = s.decoder.decode(receipt.Logs)
+	}
+
+	if err = handle(tx, block, receipt, logs); err != nil {
+		f.Attempts++
+		f.Error = err.Error()
+		f.LastFailed = time.Now()
+		log.Log.Error("replay tx: ", f.TxHash.Hex(), " attempt ", f.Attempts, " err: ", err)
+		return store.Put(f)
+	}
+
+	log.Log.Info("replayed tx: ", f.TxHash.Hex(), " after ", f.Attempts, " failed attempts")
+	return store.Delete(f.Namespace, f.TxHash)
+}
@@ -0,0 +1,193 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+func TestFileDeadLetterStoreReopen(t *testing.T) {
+	log.Init(logrus.ErrorLevel)
+	path := filepath.Join(t.TempDir(), "deadletters.jsonl")
+
+	s, err := NewFileDeadLetterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for i := int64(1); i <= 3; i++ {
+		err = s.Put(&FailedTx{Namespace: "scan", TxHash: common.BigToHash(big.NewInt(i)), Block: uint64(i), Attempts: 1, FirstFailed: now.Add(time.Duration(i) * time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Put(&FailedTx{Namespace: "other", TxHash: common.BigToHash(big.NewInt(1))})
+	s.Put(&FailedTx{Namespace: "scan", TxHash: common.BigToHash(big.NewInt(2)), Block: 2, Attempts: 3, FirstFailed: now.Add(2 * time.Second)})
+	s.Delete("scan", common.BigToHash(big.NewInt(1)))
+	s.Close()
+
+	s, err = NewFileDeadLetterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	list, err := s.List("scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Block != 2 || list[0].Attempts != 3 || list[1].Block != 3 {
+		t.Fatalf("unexpected records %+v", list)
+	}
+	if !list[1].FirstFailed.Equal(now.Add(3 * time.Second)) {
+		t.Fatalf("first failed %v not kept", list[1].FirstFailed)
+	}
+}
+
+func TestScannerReplayFailed(t *testing.T) {
+	node := newTestNode(t)
+	to := common.HexToAddress("0xd1")
+	bad := newTestTx(t, 0, to, big.NewInt(1))
+	good := newTestTx(t, 1, to, big.NewInt(1))
+	node.eth.addTx(2, bad)
+	node.eth.addTx(2, good)
+	node.eth.buildChain(1, 3, 0)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	failing := true
+	handled := 0
+	handle := func(tx *types.Transaction, block *types.Block) error {
+		if tx.Hash() == bad.Hash() && failing {
+			return errors.New("db down")
+		}
+		handled++
+		return nil
+	}
+
+	dead := NewMemoryDeadLetterStore()
+	s, err := jk.NewScanner(NewMemoryCheckpointStore(), "replay", 1, handle, WithConfirmations(0), WithDeadLetters(dead), WithReplayLimit(3, 20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	list, _ := dead.List("replay")
+	if len(list) != 1 || list[0].TxHash != bad.Hash() || list[0].Block != 2 || list[0].Error != "db down" || list[0].Attempts != 1 {
+		t.Fatalf("unexpected dead letters %+v", list)
+	}
+
+	//还没到重试时间
+	if err := s.ReplayFailed(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = dead.List("replay"); list[0].Attempts != 1 {
+		t.Fatalf("replayed before backoff, attempts %d", list[0].Attempts)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := s.ReplayFailed(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = dead.List("replay"); list[0].Attempts != 2 {
+		t.Fatalf("attempts %d, want 2", list[0].Attempts)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	s.ReplayFailed(context.Background(), nil)
+	if list, _ = dead.List("replay"); list[0].Attempts != 3 {
+		t.Fatalf("attempts %d, want 3", list[0].Attempts)
+	}
+
+	//用完重试次数后不再重放
+	failing = false
+	time.Sleep(100 * time.Millisecond)
+	s.ReplayFailed(context.Background(), nil)
+	if list, _ = dead.List("replay"); len(list) != 1 || handled != 1 {
+		t.Fatalf("exhausted record replayed, handled %d", handled)
+	}
+
+	list[0].Attempts = 1
+	dead.Put(list[0])
+	if err := s.ReplayFailed(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = dead.List("replay"); len(list) != 0 || handled != 2 {
+		t.Fatalf("record not resolved, left %d handled %d", len(list), handled)
+	}
+}
+
+func TestScannerDeadLetterRescanAndSkip(t *testing.T) {
+	node := newTestNode(t)
+	to := common.HexToAddress("0xd1")
+	bad := newTestTx(t, 0, to, big.NewInt(1))
+	node.eth.addTx(2, bad)
+	node.eth.buildChain(1, 3, 0)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	failing := true
+	handle := func(tx *types.Transaction, block *types.Block) error {
+		if failing {
+			return errors.New("db down")
+		}
+		return nil
+	}
+
+	dead := NewMemoryDeadLetterStore()
+	scan := func() {
+		s, err := jk.NewScanner(NewMemoryCheckpointStore(), "rescan", 1, handle, WithConfirmations(0), WithDeadLetters(dead), WithReplayLimit(3, time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RunOnce(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan()
+	list, _ := dead.List("rescan")
+	first := list[0].FirstFailed
+
+	//检查点没保存住, 同一个区块又扫了一遍
+	scan()
+	if list, _ = dead.List("rescan"); len(list) != 1 || list[0].Attempts != 2 || !list[0].FirstFailed.Equal(first) {
+		t.Fatalf("rescan reset the record %+v", list)
+	}
+
+	//前面一条记录的区块取不到, 后面的照样重放
+	node.eth.mu.Lock()
+	node.eth.prunedBlocks = map[uint64]bool{1: true}
+	node.eth.mu.Unlock()
+	dead.Put(&FailedTx{Namespace: "rescan", TxHash: common.BigToHash(big.NewInt(1)), Block: 1, Attempts: 1, FirstFailed: first.Add(-time.Second)})
+
+	failing = false
+	s, err := jk.NewScanner(NewMemoryCheckpointStore(), "rescan", 1, handle, WithConfirmations(0), WithDeadLetters(dead), WithReplayLimit(3, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := s.ReplayFailed(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = dead.List("rescan"); len(list) != 1 || list[0].Block != 1 {
+		t.Fatalf("records left %+v", list)
+	}
+}
@@ -0,0 +1,189 @@
+package blx
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// AddressRegistry tells the DepositWatcher which addresses are ours. Contains is called for every
+// transaction and transfer log, so it should answer from memory or a local index.
+type AddressRegistry interface {
+	Contains(address common.Address) (bool, error)
+}
+
+// MemoryAddressRegistry is an AddressRegistry backed by a map.
+type MemoryAddressRegistry struct {
+	mu        sync.RWMutex
+	addresses map[common.Address]struct{}
+}
+
+func NewMemoryAddressRegistry(addresses ...common.Address) *MemoryAddressRegistry {
+	r := &MemoryAddressRegistry{addresses: make(map[common.Address]struct{}, len(addresses))}
+	r.Add(addresses...)
+	return r
+}
+
+func (r *MemoryAddressRegistry) Add(addresses ...common.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, a := range addresses {
+		r.addresses[a] = struct{}{}
+	}
+}
+
+func (r *MemoryAddressRegistry) Remove(addresses ...common.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, a := range addresses {
+		delete(r.addresses, a)
+	}
+}
+
+func (r *MemoryAddressRegistry) Contains(address common.Address) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.addresses[address]
+	return ok, nil
+}
+
+func (r *MemoryAddressRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.addresses)
+}
+
+type DepositKind int
+
+const (
+	NativeDeposit DepositKind = iota
+	TokenDeposit
+)
+
+func (k DepositKind) String() string {
+	if k == TokenDeposit {
+		return "token"
+	}
+	return "native"
+}
+
+// Deposit is value received by a registered address. Token is empty for the native coin.
+type Deposit struct {
+	Kind          DepositKind
+	Token         common.Address
+	From          common.Address
+	To            common.Address
+	Value         *big.Int
+	TxHash        common.Hash
+	LogIndex      uint
+	Block         uint64
+	BlockHash     common.Hash
+	Confirmations uint64
+}
+
+// Key identifies the deposit across rescans and restarts, credit each key only once.
+func (d *Deposit) Key() string {
+	if d.Kind == NativeDeposit {
+		return d.TxHash.Hex() + ":native"
+	}
+	return fmt.Sprintf("%s:%d", d.TxHash.Hex(), d.LogIndex)
+}
+
+// DepositWatcher scans confirmed blocks for native transfers and ERC-20 Transfer logs to the
+// addresses in its registry. Native value moved by contract internal calls is not seen.
+type DepositWatcher struct {
+	*Scanner
+	registry AddressRegistry
+	handle   func(d Deposit) error
+}
+
+// NewDepositWatcher builds a scanner with receipts on top of store and namespace, see NewScanner.
+// Use OnRollback to revert deposits of orphaned blocks.
+func (j *Jk) NewDepositWatcher(store CheckpointStore, namespace string, startNumber uint64, registry AddressRegistry, handle func(d Deposit) error, opts ...ScanOption) (*DepositWatcher, error) {
+	if registry == nil {
+		return nil, errors.New("address registry can not be nil")
+	}
+	if handle == nil {
+		return nil, errors.New("handle can not be nil")
+	}
+
+	w := &DepositWatcher{registry: registry, handle: handle}
+	s, err := j.NewReceiptScanner(store, namespace, startNumber, w.handleTx, append(opts, WithReceipts())...)
+	if err != nil {
+		return nil, err
+	}
+	w.Scanner = s
+	return w, nil
+}
+
+func (w *DepositWatcher) handleTx(tx *types.Transaction, block *types.Block, receipt *types.Receipt, logs []DecodedLog) error {
+	if receipt == nil || receipt.Status != types.ReceiptStatusSuccessful {
+		return nil
+	}
+
+	confirmations := uint64(0)
+	if head := w.Head(); head >= block.NumberU64() {
+		confirmations = head - block.NumberU64() + 1
+	}
+	//回执里是节点返回的区块哈希, 非 geth 格式的链上本地算的 block.Hash() 节点查不到
+	blockHash := receipt.BlockHash
+	if blockHash == (common.Hash{}) {
+		blockHash = block.Hash()
+	}
+	newDeposit := func(kind DepositKind) Deposit {
+		return Deposit{
+			Kind:          kind,
+			TxHash:        tx.Hash(),
+			Block:         block.NumberU64(),
+			BlockHash:     blockHash,
+			Confirmations: confirmations,
+		}
+	}
+
+	if to := tx.To(); to != nil && tx.Value().Sign() > 0 {
+		ok, err := w.registry.Contains(*to)
+		if err != nil {
+			return err
+		}
+		if ok {
+			from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+			if err != nil {
+				log.Log.Error("recover sender of ", tx.Hash().Hex(), " err: ", err)
+				return err
+			}
+
+			d := newDeposit(NativeDeposit)
+			d.From, d.To, d.Value = from, *to, tx.Value()
+			if err := w.handle(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, l := range receipt.Logs {
+		transfers := decodeTransfers(*l)
+		if len(transfers) != 1 || transfers[0].Standard != ERC20 {
+			continue
+		}
+		t := transfers[0]
+		ours, err := w.registry.Contains(t.To)
+		if err != nil {
+			return err
+		}
+		if !ours {
+			continue
+		}
+
+		d := newDeposit(TokenDeposit)
+		d.Token, d.From, d.To, d.Value, d.LogIndex = t.Token, t.From, t.To, t.Value, t.LogIndex
+		if err := w.handle(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package blx
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+)
+
+func TestDepositWatcher(t *testing.T) {
+	node := newTestNode(t)
+	node.eth.foreignHashes = true
+	token := common.HexToAddress("0xaa")
+	ours := common.HexToAddress("0xd1")
+	stranger := common.HexToAddress("0xd2")
+
+	native := newTestTx(t, 0, ours, big.NewInt(7))
+	other := newTestTx(t, 0, stranger, big.NewInt(9))
+	reverted := newTestTx(t, 1, ours, big.NewInt(3))
+	call := newTestTx(t, 0, token, big.NewInt(0))
+	node.eth.addTx(1, native)
+	node.eth.addTx(1, other)
+	node.eth.addTx(2, reverted)
+	node.eth.addTx(2, call)
+	node.eth.buildChain(1, 5, 0)
+
+	node.eth.setReceipt(&types.Receipt{Status: types.ReceiptStatusFailed, TxHash: reverted.Hash(), GasUsed: 21000})
+	in := transferLog(token, stranger, ours, 500, 2, 3)
+	out := transferLog(token, ours, stranger, 100, 2, 4)
+	in.TxHash, out.TxHash = call.Hash(), call.Hash()
+	node.eth.setReceipt(&types.Receipt{Status: types.ReceiptStatusSuccessful, TxHash: call.Hash(), GasUsed: 50000, Logs: []*types.Log{&in, &out}})
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	var deposits []Deposit
+	handle := func(d Deposit) error {
+		deposits = append(deposits, d)
+		return nil
+	}
+	w, err := jk.NewDepositWatcher(NewMemoryCheckpointStore(), "deposits", 1, NewMemoryAddressRegistry(ours), handle, WithConfirmations(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deposits) != 2 {
+		t.Fatalf("got %d deposits, want 2: %+v", len(deposits), deposits)
+	}
+	d := deposits[0]
+	if d.Kind != NativeDeposit || d.To != ours || d.Value.Int64() != 7 || d.Block != 1 || d.Confirmations != 5 {
+		t.Fatalf("unexpected native deposit %+v", d)
+	}
+	if d.From == (common.Address{}) || d.Key() != native.Hash().Hex()+":native" {
+		t.Fatalf("unexpected native sender or key %+v", d)
+	}
+	//节点返回的区块哈希, 不是本地算的
+	if d.BlockHash != node.eth.hashOf(1) {
+		t.Fatalf("block hash %s, want the node's %s", d.BlockHash.Hex(), node.eth.hashOf(1).Hex())
+	}
+	d = deposits[1]
+	if d.Kind != TokenDeposit || d.Token != token || d.From != stranger || d.Value.Int64() != 500 || d.Confirmations != 4 {
+		t.Fatalf("unexpected token deposit %+v", d)
+	}
+	if d.BlockHash != node.eth.hashOf(2) {
+		t.Fatalf("token deposit block hash %s", d.BlockHash.Hex())
+	}
+	if d.Key() != call.Hash().Hex()+":3" {
+		t.Fatalf("unexpected token key %s", d.Key())
+	}
+}
@@ -0,0 +1,194 @@
+package blx
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+var AllowanceLessAmountError = newCodedError(CodeAllowanceLessAmount)
+
+// TokenInfo is the metadata and supply of an ERC-20 token.
+type TokenInfo struct {
+	Address     common.Address
+	Name        string
+	Symbol      string
+	Decimals    uint8
+	TotalSupply *big.Int
+}
+
+func (j *Jk) erc20(contractAddr string) (*Contract, error) {
+	if contractAddr == "" {
+		return nil, ContractNotEmpty
+	}
+	if !common.IsHexAddress(contractAddr) {
+		return nil, NotAnHexAddress.With("address", contractAddr)
+	}
+	return &Contract{jk: j, address: common.HexToAddress(contractAddr), abi: j.tokens.ABI()}, nil
+}
+
+func hexAddresses(addresses ...string) ([]common.Address, error) {
+	parsed := make([]common.Address, len(addresses))
+	for i, a := range addresses {
+		if !common.IsHexAddress(a) {
+			return nil, NotAnHexAddress.With("address", a)
+		}
+		parsed[i] = common.HexToAddress(a)
+	}
+	return parsed, nil
+}
+
+// GetAllowance returns how many base units spender may still transfer from owner.
+func (j *Jk) GetAllowance(ctx context.Context, contractAddr string, owner string, spender string, at ...BlockRef) (*big.Int, error) {
+	token, err := j.erc20(contractAddr)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := hexAddresses(owner, spender)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := token.CallAt(ctx, blockOf(at), "allowance", addresses[0], addresses[1])
+	if err != nil {
+		return nil, err
+	}
+	return out[0].(*big.Int), nil
+}
+
+func (j *Jk) GetTotalSupply(ctx context.Context, contractAddr string, at ...BlockRef) (*big.Int, error) {
+	token, err := j.erc20(contractAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := token.CallAt(ctx, blockOf(at), "totalSupply")
+	if err != nil {
+		return nil, err
+	}
+	return out[0].(*big.Int), nil
+}
+
+// GetTokenInfo reads the total supply of the token at the latest block unless at is given, name,
+// symbol and decimals come from the token cache.
+func (j *Jk) GetTokenInfo(ctx context.Context, contractAddr string, at ...BlockRef) (*TokenInfo, error) {
+	token, err := j.erc20(contractAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := j.tokens.Metadata(ctx, token.Address())
+	if err != nil {
+		return nil, err
+	}
+	info := &TokenInfo{Address: token.Address(), Name: meta.Name, Symbol: meta.Symbol, Decimals: meta.Decimals}
+
+	//总量会变, 不缓存
+	out, err := token.CallAt(ctx, blockOf(at), "totalSupply")
+	if err != nil {
+		return nil, err
+	}
+	info.TotalSupply = out[0].(*big.Int)
+
+	log.Log.Debug("token info: ", info.Symbol, " name: ", info.Name, " decimals: ", info.Decimals, " totalSupply: ", info.TotalSupply)
+	return info, nil
+}
+
+// ApproveSync lets spender transfer amount base units of the signer's tokens and waits for the
+// receipt. When both the current allowance and amount are not zero the allowance is first reset to
+// zero and mined, so spender can not spend the old and the new allowance together. Nothing is sent
+// and the hash is empty when the allowance already is amount.
+func (j *Jk) ApproveSync(ctx context.Context, signer Signer, contractAddr string, spender string, amount *big.Int) (hash string, err error) {
+	return j.approve(ctx, signer, contractAddr, spender, amount, true)
+}
+
+// ApproveAsync is ApproveSync without waiting for the final approve, a reset to zero is still
+// waited for.
+func (j *Jk) ApproveAsync(ctx context.Context, signer Signer, contractAddr string, spender string, amount *big.Int) (hash string, err error) {
+	return j.approve(ctx, signer, contractAddr, spender, amount, false)
+}
+
+func (j *Jk) approve(ctx context.Context, signer Signer, contractAddr string, spender string, amount *big.Int, wait bool) (string, error) {
+	if amount == nil || amount.Sign() < 0 {
+		return "", AmountError
+	}
+	token, err := j.erc20(contractAddr)
+	if err != nil {
+		return "", err
+	}
+	addresses, err := hexAddresses(spender)
+	if err != nil {
+		return "", err
+	}
+
+	current, err := j.GetAllowance(ctx, contractAddr, signer.Address().Hex(), spender)
+	if err != nil {
+		return "", err
+	}
+	if current.Cmp(amount) == 0 {
+		log.Log.Debug("allowance of ", spender, " already ", amount)
+		return "", nil
+	}
+
+	//先清零再设置新额度, 避免 spender 抢先花掉旧额度后再花新额度
+	if current.Sign() > 0 && amount.Sign() > 0 {
+		hash, err := token.Transact(ctx, signer, "approve", nil, addresses[0], big.NewInt(0))
+		if err != nil {
+			log.Log.Error("reset allowance of ", spender, " tx: ", hash, " err: ", err)
+			return "", err
+		}
+	}
+
+	if wait {
+		return token.Transact(ctx, signer, "approve", nil, addresses[0], amount)
+	}
+	return token.TransactAsync(ctx, signer, "approve", nil, addresses[0], amount)
+}
+
+// TransferFromSync moves amount base units from owner to receive with the allowance the signer got
+// from owner, and waits for the receipt.
+func (j *Jk) TransferFromSync(ctx context.Context, signer Signer, contractAddr string, owner string, receive string, amount *big.Int) (hash string, err error) {
+	return j.transferFrom(ctx, signer, contractAddr, owner, receive, amount, true)
+}
+
+// TransferFromAsync is TransferFromSync without waiting for the receipt.
+func (j *Jk) TransferFromAsync(ctx context.Context, signer Signer, contractAddr string, owner string, receive string, amount *big.Int) (hash string, err error) {
+	return j.transferFrom(ctx, signer, contractAddr, owner, receive, amount, false)
+}
+
+func (j *Jk) transferFrom(ctx context.Context, signer Signer, contractAddr string, owner string, receive string, amount *big.Int, wait bool) (string, error) {
+	if amount == nil || amount.Sign() < 0 {
+		return "", AmountError
+	}
+	token, err := j.erc20(contractAddr)
+	if err != nil {
+		return "", err
+	}
+	addresses, err := hexAddresses(owner, receive)
+	if err != nil {
+		return "", err
+	}
+
+	allowance, err := j.GetAllowance(ctx, contractAddr, owner, signer.Address().Hex())
+	if err != nil {
+		return "", err
+	}
+	if allowance.Cmp(amount) < 0 {
+		return "", AllowanceLessAmountError.With("owner", owner).With("spender", signer.Address().Hex()).With("need", amount).With("available", allowance)
+	}
+
+	balance, _, err := j.GetBalanceOfContractBig(ctx, owner, contractAddr)
+	if err != nil {
+		return "", err
+	}
+	if balance.Cmp(amount) < 0 {
+		return "", BalanceLessAmountError.With("address", owner).With("token", contractAddr).With("need", amount).With("available", balance)
+	}
+
+	if wait {
+		return token.Transact(ctx, signer, "transferFrom", nil, addresses[0], addresses[1], amount)
+	}
+	return token.TransactAsync(ctx, signer, "transferFrom", nil, addresses[0], addresses[1], amount)
+}
@@ -0,0 +1,92 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+func TestErc20Surface(t *testing.T) {
+	node := newTestNode(t)
+	token := common.HexToAddress("0xaa")
+	spender := common.HexToAddress("0xb1")
+	erc20, err := abi.JSON(strings.NewReader(AbiErc20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := func(method string, values ...interface{}) {
+		out, err := erc20.Methods[method].Outputs.Pack(values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.eth.setMethodResult(token, erc20.Methods[method], out)
+	}
+	set("name", "Fibo Token")
+	set("symbol", "FT")
+	set("decimals", uint8(6))
+	set("totalSupply", big.NewInt(1e12))
+	set("allowance", big.NewInt(5))
+	set("balanceOf", big.NewInt(100))
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel), WithLegacyTx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	info, err := jk.GetTokenInfo(context.Background(), token.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "Fibo Token" || info.Symbol != "FT" || info.Decimals != 6 || info.TotalSupply.Int64() != 1e12 {
+		t.Fatalf("unexpected token info %+v", info)
+	}
+
+	key, _ := crypto.GenerateKey()
+	signer := NewKeySigner(key)
+
+	//已有额度时先清零再设置
+	if _, err := jk.ApproveSync(context.Background(), signer, token.Hex(), spender.Hex(), big.NewInt(9)); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.eth.sent) != 2 {
+		t.Fatalf("sent %d transactions, want reset and approve", len(node.eth.sent))
+	}
+	for i, want := range []int64{0, 9} {
+		args, err := erc20.Methods["approve"].Inputs.Unpack(node.eth.sent[i].Data()[4:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if args[0].(common.Address) != spender || args[1].(*big.Int).Int64() != want {
+			t.Fatalf("approve %d: unexpected args %v", i, args)
+		}
+		if node.eth.sent[i].Nonce() != uint64(i) {
+			t.Fatalf("approve %d: nonce %d", i, node.eth.sent[i].Nonce())
+		}
+	}
+
+	hash, err := jk.ApproveAsync(context.Background(), signer, token.Hex(), spender.Hex(), big.NewInt(5))
+	if err != nil || hash != "" || len(node.eth.sent) != 2 {
+		t.Fatalf("approve of the current allowance sent a transaction %q %v", hash, err)
+	}
+
+	owner := common.HexToAddress("0xa1")
+	_, err = jk.TransferFromSync(context.Background(), signer, token.Hex(), owner.Hex(), spender.Hex(), big.NewInt(6))
+	if !errors.Is(err, AllowanceLessAmountError) {
+		t.Fatalf("err %v, want AllowanceLessAmountError", err)
+	}
+	if _, err = jk.TransferFromAsync(context.Background(), signer, token.Hex(), owner.Hex(), spender.Hex(), big.NewInt(4)); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := erc20.Methods["transferFrom"].Inputs.Unpack(node.eth.sent[2].Data()[4:])
+	if args[0].(common.Address) != owner || args[1].(common.Address) != spender || args[2].(*big.Int).Int64() != 4 {
+		t.Fatalf("unexpected transferFrom args %v", args)
+	}
+}
@@ -1,14 +1,279 @@
 package blx
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrorCode identifies a JkError independent of the message language, safe to compare and store.
+type ErrorCode string
+
+const (
+	CodeUnknown                 ErrorCode = "UNKNOWN"
+	CodePoolClosed              ErrorCode = "POOL_CLOSED"
+	CodeNoHealthyConnection     ErrorCode = "NO_HEALTHY_CONNECTION"
+	CodeBalanceLessGas          ErrorCode = "BALANCE_LESS_GAS"
+	CodeBalanceLessGasAddAmount ErrorCode = "BALANCE_LESS_GAS_ADD_AMOUNT"
+	CodeBalanceLessAmount       ErrorCode = "BALANCE_LESS_AMOUNT"
+	CodePrivateKey              ErrorCode = "PRIVATE_KEY"
+	CodeAmount                  ErrorCode = "AMOUNT"
+	CodeReadTransactionTimeOut  ErrorCode = "READ_TRANSACTION_TIMEOUT"
+	CodeSendTransactionFailed   ErrorCode = "SEND_TRANSACTION_FAILED"
+	CodeContractNotEmpty        ErrorCode = "CONTRACT_EMPTY"
+	CodeNotAnHexAddress         ErrorCode = "NOT_HEX_ADDRESS"
+	CodeNonceNotEmpty           ErrorCode = "NONCE_EMPTY"
+	CodeNonceTooSmall           ErrorCode = "NONCE_TOO_SMALL"
+	CodeNamespace               ErrorCode = "INVALID_NAMESPACE"
+	CodeReorgTooDeep            ErrorCode = "REORG_TOO_DEEP"
+	CodeParentHashMismatch      ErrorCode = "PARENT_HASH_MISMATCH"
+	CodeScannerRunning          ErrorCode = "SCANNER_RUNNING"
+	CodeNoDeadLetterStore       ErrorCode = "NO_DEAD_LETTER_STORE"
+	CodeTransactionDropped      ErrorCode = "TRANSACTION_DROPPED"
+	CodeTransactionReplaced     ErrorCode = "TRANSACTION_REPLACED"
+	CodeNoWsEndpoint            ErrorCode = "NO_WS_ENDPOINT"
+	CodeExecutionReverted       ErrorCode = "EXECUTION_REVERTED"
+	CodeAllowanceLessAmount     ErrorCode = "ALLOWANCE_LESS_AMOUNT"
+	CodeCallFailed              ErrorCode = "CALL_FAILED"
+	CodeSnapshotChanged         ErrorCode = "SNAPSHOT_CHANGED"
+)
+
+type Language int
+
+const (
+	Chinese Language = iota
+	English
+)
+
+var catalogs = map[Language]map[ErrorCode]string{
+	Chinese: {
+		CodePoolClosed:              "请求池子用尽",
+		CodeNoHealthyConnection:     "没有可用的节点连接",
+		CodeBalanceLessGas:          "交易费不足",
+		CodeBalanceLessGasAddAmount: "余额小于交易费+转账数量",
+		CodeBalanceLessAmount:       "转账数量不足",
+		CodePrivateKey:              "错误的私钥",
+		CodeAmount:                  "金额有误",
+		CodeReadTransactionTimeOut:  "读取交易信息失败",
+		CodeSendTransactionFailed:   "交易失败",
+		CodeContractNotEmpty:        "合约地址不能为空",
+		CodeNotAnHexAddress:         "不是合法0x地址",
+		CodeNonceNotEmpty:           "交易序号不能为空",
+		CodeNonceTooSmall:           "交易序号太小",
+		CodeNamespace:               "扫块命名空间不合法",
+		CodeReorgTooDeep:            "区块回滚深度超过保存的区块数",
+		CodeParentHashMismatch:      "区块的父哈希和已处理的区块不一致, 但已处理的区块仍在主链上",
+		CodeScannerRunning:          "扫块已经在运行",
+		CodeNoDeadLetterStore:       "没有配置失败交易存储",
+		CodeTransactionDropped:      "交易被节点丢弃",
+		CodeTransactionReplaced:     "交易被同序号的其他交易替换",
+		CodeNoWsEndpoint:            "没有配置 websocket 节点",
+		CodeExecutionReverted:       "合约执行回滚",
+		CodeAllowanceLessAmount:     "授权额度小于转账数量",
+		CodeCallFailed:              "合约调用失败",
+		CodeSnapshotChanged:         "读取期间区块被重组替换",
+	},
+	English: {
+		CodePoolClosed:              "client pool closed",
+		CodeNoHealthyConnection:     "no healthy node connection",
+		CodeBalanceLessGas:          "balance less than gas fee",
+		CodeBalanceLessGasAddAmount: "balance less than gas fee plus amount",
+		CodeBalanceLessAmount:       "token balance less than amount",
+		CodePrivateKey:              "invalid private key",
+		CodeAmount:                  "invalid amount",
+		CodeReadTransactionTimeOut:  "timed out reading transaction",
+		CodeSendTransactionFailed:   "transaction failed",
+		CodeContractNotEmpty:        "contract address can not be empty",
+		CodeNotAnHexAddress:         "not a 0x hex address",
+		CodeNonceNotEmpty:           "nonce can not be empty",
+		CodeNonceTooSmall:           "nonce too small",
+		CodeNamespace:               "invalid scanner namespace",
+		CodeReorgTooDeep:            "reorg deeper than the kept blocks",
+		CodeParentHashMismatch:      "block parent hash differs from the handled block that is still canonical",
+		CodeScannerRunning:          "scanner already running",
+		CodeNoDeadLetterStore:       "no dead letter store configured",
+		CodeTransactionDropped:      "transaction dropped by the node",
+		CodeTransactionReplaced:     "transaction replaced by another one with the same nonce",
+		CodeNoWsEndpoint:            "no websocket endpoint configured",
+		CodeExecutionReverted:       "execution reverted",
+		CodeAllowanceLessAmount:     "allowance less than amount",
+		CodeCallFailed:              "contract call failed",
+		CodeSnapshotChanged:         "block replaced by a reorg while reading",
+	},
+}
+
+var langMu sync.RWMutex
+var language = Chinese
+
+// SetLanguage picks the catalog JkError.Error uses, Chinese by default.
+func SetLanguage(lang Language) {
+	langMu.Lock()
+	defer langMu.Unlock()
+	language = lang
+}
+
+// RegisterMessages adds or overrides messages of a catalog, for example a new language.
+func RegisterMessages(lang Language, messages map[ErrorCode]string) {
+	langMu.Lock()
+	defer langMu.Unlock()
+
+	if catalogs[lang] == nil {
+		catalogs[lang] = make(map[ErrorCode]string)
+	}
+	for code, msg := range messages {
+		catalogs[lang][code] = msg
+	}
+}
+
+func currentLanguage() Language {
+	langMu.RLock()
+	defer langMu.RUnlock()
+	return language
+}
+
+// CodedError is implemented by the errors of this package that carry an ErrorCode.
+type CodedError interface {
+	error
+	Code() ErrorCode
+}
+
+// CodeOf returns the code of the first CodedError in the chain of err, CodeUnknown when none.
+func CodeOf(err error) ErrorCode {
+	var coded CodedError
+	if errors.As(err, &coded) {
+		return coded.Code()
+	}
+	return CodeUnknown
+}
+
+// JkError is an error with a stable code and a message from the catalogs. The package variables
+// are JkErrors; functions that add context such as the address or the needed and available balance
+// return a *ContextError that unwraps to the package variable itself. Such errors are not == to the
+// variable, compare them with errors.Is or by Code.
 type JkError struct {
+	code    ErrorCode
 	message string
 }
 
-//JkError实现了 Error() 方法的对象都可以
+// JkError实现了 Error() 方法的对象都可以
 func (e *JkError) Error() string {
-	return e.message
+	return e.Message(currentLanguage())
 }
 
+// NewJkError makes an error without code, it only matches itself.
 func NewJkError(message string) *JkError {
-	return &JkError{message: message}
+	return &JkError{code: CodeUnknown, message: message}
+}
+
+func newCodedError(code ErrorCode) *JkError {
+	return &JkError{code: code, message: catalogs[Chinese][code]}
+}
+
+func (e *JkError) Code() ErrorCode {
+	return e.code
+}
+
+// Message is the message of lang without context, falling back to the message the error was made with.
+func (e *JkError) Message(lang Language) string {
+	langMu.RLock()
+	defer langMu.RUnlock()
+
+	if msg, ok := catalogs[lang][e.code]; ok && e.code != CodeUnknown {
+		return msg
+	}
+	return e.message
+}
+
+// With returns e carrying key=value, e itself is not changed.
+func (e *JkError) With(key string, value interface{}) *ContextError {
+	return (&ContextError{err: e}).With(key, value)
+}
+
+// Wrap returns e caused by err, e itself is not changed.
+func (e *JkError) Wrap(err error) *ContextError {
+	return (&ContextError{err: e}).Wrap(err)
+}
+
+// Is matches errors with the same code, errors without code only match themselves.
+func (e *JkError) Is(target error) bool {
+	t, ok := target.(*JkError)
+	if !ok {
+		return false
+	}
+	return e == t || (e.code != CodeUnknown && e.code == t.code)
+}
+
+// errorField 错误的上下文, 按添加的顺序输出
+type errorField struct {
+	key   string
+	value interface{}
+}
+
+// ContextError is a JkError with context and the underlying error. It unwraps to the JkError, so
+// errors.Is and errors.As find the package variable, and the underlying error is found too.
+type ContextError struct {
+	err    *JkError
+	fields []errorField
+	cause  error
+}
+
+func (e *ContextError) Error() string {
+	msg := e.err.Error()
+	if len(e.fields) > 0 {
+		pairs := make([]string, len(e.fields))
+		for i, f := range e.fields {
+			pairs[i] = fmt.Sprintf("%s=%v", f.key, f.value)
+		}
+		msg += " (" + strings.Join(pairs, ", ") + ")"
+	}
+	if e.cause != nil {
+		msg += ": " + e.cause.Error()
+	}
+	return msg
+}
+
+func (e *ContextError) Code() ErrorCode {
+	return e.err.code
+}
+
+// Context returns the attached key values.
+func (e *ContextError) Context() map[string]interface{} {
+	ctx := make(map[string]interface{}, len(e.fields))
+	for _, f := range e.fields {
+		ctx[f.key] = f.value
+	}
+	return ctx
+}
+
+// With returns a copy of e carrying key=value.
+func (e *ContextError) With(key string, value interface{}) *ContextError {
+	cp := *e
+	cp.fields = append(append([]errorField(nil), e.fields...), errorField{key, value})
+	return &cp
+}
+
+// Wrap returns a copy of e caused by err.
+func (e *ContextError) Wrap(err error) *ContextError {
+	cp := *e
+	cp.cause = err
+	return &cp
+}
+
+// Unwrap returns the JkError, the package variable for errors of this package.
+func (e *ContextError) Unwrap() error {
+	return e.err
+}
+
+// Is lets errors.Is match the underlying error as well.
+func (e *ContextError) Is(target error) bool {
+	return e.cause != nil && errors.Is(e.cause, target)
+}
+
+// As finds the JkError first and the underlying error after.
+func (e *ContextError) As(target interface{}) bool {
+	if errors.As(e.err, target) {
+		return true
+	}
+	return e.cause != nil && errors.As(e.cause, target)
 }
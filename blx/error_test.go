@@ -0,0 +1,54 @@
+package blx
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestJkErrorCodesAndContext(t *testing.T) {
+	cause := errors.New("connection reset")
+	err := BalanceLessGasError.With("need", big.NewInt(10)).With("available", big.NewInt(3)).Wrap(cause)
+	wrapped := fmt.Errorf("send: %w", err)
+
+	if !errors.Is(wrapped, BalanceLessGasError) || errors.Is(wrapped, BalanceLessGasAddAmountError) {
+		t.Fatal("errors.Is does not match by code")
+	}
+	if !errors.Is(wrapped, cause) {
+		t.Fatal("cause lost")
+	}
+	if CodeOf(wrapped) != CodeBalanceLessGas || CodeOf(cause) != CodeUnknown {
+		t.Fatalf("unexpected code %s", CodeOf(wrapped))
+	}
+
+	var ctxErr *ContextError
+	if !errors.As(wrapped, &ctxErr) || ctxErr.Context()["need"].(*big.Int).Int64() != 10 {
+		t.Fatalf("context lost: %v", ctxErr)
+	}
+	//带上下文的错误解包后就是包里的变量本身
+	var jkErr *JkError
+	if !errors.As(wrapped, &jkErr) || jkErr != BalanceLessGasError || errors.Unwrap(err) != BalanceLessGasError {
+		t.Fatalf("unwraps to %v, want the package variable", jkErr)
+	}
+	if err.Error() != "交易费不足 (need=10, available=3): connection reset" {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+	if BalanceLessGasError.Error() != "交易费不足" {
+		t.Fatal("package error changed by With")
+	}
+
+	SetLanguage(English)
+	defer SetLanguage(Chinese)
+	if err.Error() != "balance less than gas fee (need=10, available=3): connection reset" {
+		t.Fatalf("unexpected english message %q", err.Error())
+	}
+
+	custom := NewJkError("自定义")
+	if custom.Error() != "自定义" || errors.Is(NewJkError("自定义"), custom) || !errors.Is(custom, custom) {
+		t.Fatal("uncoded errors must only match themselves")
+	}
+	if CodeOf(&RevertError{}) != CodeExecutionReverted {
+		t.Fatal("revert error without code")
+	}
+}
@@ -0,0 +1,937 @@
+package blx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeEth 模拟节点的 eth 命名空间
+type fakeEth struct {
+	mu       sync.Mutex
+	head     uint64
+	baseFee  *big.Int
+	gasPrice *big.Int
+	tip      *big.Int
+	headers  map[uint64]*types.Header
+	txs      map[uint64]types.Transactions
+	receipts map[common.Hash]*types.Receipt
+	logs     []types.Log
+	logCalls int
+	pool     map[common.Hash]*types.Transaction
+	nonces   map[common.Address]uint64
+	reverts  map[common.Address][]byte
+	results  map[common.Address][]byte
+	methods  map[common.Address]map[string][]byte
+	sent     []*types.Transaction
+	calls    map[string]int
+	balances map[common.Address]*big.Int
+	archive  map[uint64]map[common.Address]*big.Int
+	codes    map[common.Address][]byte
+	blocks   []fakeBlock
+	//foreignHashes 模拟非 geth 格式的链, 节点返回的哈希和本地算出的不同
+	foreignHashes bool
+	//brokenParents 返回的 parentHash 和上一个区块对不上
+	brokenParents bool
+	//noBlockHash 模拟不支持 EIP-1898 按哈希指定区块的节点
+	noBlockHash bool
+	//multicall 模拟的 Multicall3 合约地址, 为空时没有部署
+	multicall common.Address
+	//dropSends 接下来这么多笔广播收下交易后直接断开连接, 不返回结果
+	dropSends int
+	//poolSends 收到的交易只放进交易池, 不打包
+	poolSends bool
+	//reorgOnBalance 接下来这么多次 eth_getBalance 之后把读到的区块换成另一个分叉
+	reorgOnBalance int
+	//prunedBlocks 这些高度的区块节点已经不提供了
+	prunedBlocks map[uint64]bool
+
+	headSubs    map[chan *types.Header]bool
+	pendingSubs map[chan common.Hash]bool
+	logSubs     map[chan types.Log]bool
+}
+
+func (f *fakeEth) BlockNumber() (hexutil.Uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return hexutil.Uint64(f.head), nil
+}
+
+func (f *fakeEth) ChainId() (*hexutil.Big, error) {
+	return (*hexutil.Big)(big.NewInt(1337)), nil
+}
+
+// fakeBlock 区块参数, 哈希按 EIP-1898 的对象传
+type fakeBlock struct {
+	tag  string
+	hash *common.Hash
+}
+
+func (b *fakeBlock) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		var obj struct {
+			BlockHash *common.Hash `json:"blockHash"`
+		}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+		b.hash = obj.BlockHash
+		return nil
+	}
+	return json.Unmarshal(data, &b.tag)
+}
+
+// height 区块参数对应的高度, 记录下来给测试检查
+func (f *fakeEth) height(block fakeBlock) (uint64, error) {
+	f.blocks = append(f.blocks, block)
+	if block.hash != nil {
+		if f.noBlockHash {
+			return 0, fmt.Errorf("invalid block number %s", block.hash.Hex())
+		}
+		for n, h := range f.headers {
+			if f.reported(h.Hash()) == *block.hash {
+				return n, nil
+			}
+		}
+		//没有 buildChain 的高度, 区块是临时生成的
+		for n := uint64(0); n <= f.head; n++ {
+			if _, ok := f.headers[n]; !ok && f.reported(f.newHeader(n, common.Hash{}, 0).Hash()) == *block.hash {
+				return n, nil
+			}
+		}
+		return 0, fmt.Errorf("header for hash %s not found", block.hash.Hex())
+	}
+	switch block.tag {
+	case "", "latest", "pending", "finalized":
+		return f.head, nil
+	}
+	return strconv.ParseUint(block.tag, 0, 64)
+}
+
+func (f *fakeEth) GetBalance(address common.Address, block fakeBlock) (*hexutil.Big, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	height, err := f.height(block)
+	if err != nil {
+		return nil, err
+	}
+	if f.reorgOnBalance > 0 {
+		if f.headers == nil {
+			f.headers = make(map[uint64]*types.Header)
+		}
+		f.headers[height] = f.newHeader(height, common.Hash{}, byte(f.reorgOnBalance))
+		f.reorgOnBalance--
+	}
+	if b, ok := f.archive[height][address]; ok {
+		return (*hexutil.Big)(b), nil
+	}
+	return (*hexutil.Big)(f.balanceOf(address)), nil
+}
+
+// setBalanceAt 指定 address 在 height 的余额, 其他高度用 setBalance 的余额
+func (f *fakeEth) setBalanceAt(address common.Address, height uint64, balance *big.Int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.archive == nil {
+		f.archive = make(map[uint64]map[common.Address]*big.Int)
+	}
+	if f.archive[height] == nil {
+		f.archive[height] = make(map[common.Address]*big.Int)
+	}
+	f.archive[height][address] = balance
+}
+
+func (f *fakeEth) GetCode(address common.Address, block fakeBlock) (hexutil.Bytes, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.height(block); err != nil {
+		return nil, err
+	}
+	return f.codes[address], nil
+}
+
+func (f *fakeEth) setCode(address common.Address, code []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.codes == nil {
+		f.codes = make(map[common.Address][]byte)
+	}
+	f.codes[address] = code
+}
+
+// blockArgs 记录的区块参数
+func (f *fakeEth) blockArgs() []fakeBlock {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeBlock(nil), f.blocks...)
+}
+
+// balanceOf 没有指定余额的地址都是 1e18
+func (f *fakeEth) balanceOf(address common.Address) *big.Int {
+	if b, ok := f.balances[address]; ok {
+		return b
+	}
+	return big.NewInt(1e18)
+}
+
+func (f *fakeEth) setBalance(address common.Address, balance *big.Int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.balances == nil {
+		f.balances = make(map[common.Address]*big.Int)
+	}
+	f.balances[address] = balance
+}
+
+func (f *fakeEth) GasPrice() (*hexutil.Big, error) {
+	return (*hexutil.Big)(f.gasPrice), nil
+}
+
+func (f *fakeEth) MaxPriorityFeePerGas() (*hexutil.Big, error) {
+	return (*hexutil.Big)(f.tip), nil
+}
+
+func (f *fakeEth) GetBlockByNumber(number string, full bool) (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	height := f.head
+	if number != "latest" && number != "pending" {
+		n, err := strconv.ParseUint(number, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		height = n
+	}
+	if f.prunedBlocks[height] {
+		return nil, errors.New("header not found")
+	}
+
+	header, ok := f.headers[height]
+	if !ok {
+		header = f.newHeader(height, common.Hash{}, 0)
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	block := make(map[string]interface{})
+	if err := json.Unmarshal(data, &block); err != nil {
+		return nil, err
+	}
+	block["hash"] = f.reported(header.Hash())
+	block["parentHash"] = f.reported(header.ParentHash)
+	if f.brokenParents {
+		block["parentHash"] = crypto.Keccak256Hash([]byte("broken"), header.Number.Bytes())
+	}
+	txs := f.txs[height]
+	if full {
+		block["transactions"] = append(types.Transactions{}, txs...)
+	} else {
+		hashes := []common.Hash{}
+		for _, tx := range txs {
+			hashes = append(hashes, tx.Hash())
+		}
+		block["transactions"] = hashes
+	}
+	block["uncles"] = []interface{}{}
+	return block, nil
+}
+
+func (f *fakeEth) newHeader(height uint64, parent common.Hash, fork byte) *types.Header {
+	txHash := types.EmptyRootHash
+	if txs := f.txs[height]; len(txs) > 0 {
+		//只要求非空, 不用真的算 trie 根
+		var data []byte
+		for _, tx := range txs {
+			data = append(data, tx.Hash().Bytes()...)
+		}
+		txHash = crypto.Keccak256Hash(data)
+	}
+	return &types.Header{
+		ParentHash:  parent,
+		UncleHash:   types.EmptyUncleHash,
+		TxHash:      txHash,
+		ReceiptHash: types.EmptyRootHash,
+		Number:      new(big.Int).SetUint64(height),
+		Difficulty:  big.NewInt(1),
+		BaseFee:     f.baseFee,
+		Extra:       []byte{fork},
+	}
+}
+
+// buildChain 生成 from..to 的区块并链到 from-1 上, fork 不同的链哈希不同
+func (f *fakeEth) buildChain(from, to uint64, fork byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.headers == nil {
+		f.headers = make(map[uint64]*types.Header)
+	}
+	for n := from; n <= to; n++ {
+		var parent common.Hash
+		if prev, ok := f.headers[n-1]; ok {
+			parent = prev.Hash()
+		}
+		f.headers[n] = f.newHeader(n, parent, fork)
+	}
+	f.head = to
+}
+
+// addTx 把 tx 放进 height, 要在 buildChain 之前调用
+func (f *fakeEth) addTx(height uint64, tx *types.Transaction) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.txs == nil {
+		f.txs = make(map[uint64]types.Transactions)
+	}
+	f.txs[height] = append(f.txs[height], tx)
+}
+
+// setReceipt 指定回执, 没有指定的已打包交易返回成功且没有日志的回执
+func (f *fakeEth) setReceipt(r *types.Receipt) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.receipts == nil {
+		f.receipts = make(map[common.Hash]*types.Receipt)
+	}
+	if r.Logs == nil {
+		r.Logs = []*types.Log{}
+	}
+	f.receipts[r.TxHash] = r
+}
+
+func (f *fakeEth) GetTransactionReceipt(hash common.Hash) (*types.Receipt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var height uint64
+	mined := false
+	for h, txs := range f.txs {
+		for _, tx := range txs {
+			if tx.Hash() == hash {
+				height, mined = h, true
+			}
+		}
+	}
+
+	r, ok := f.receipts[hash]
+	switch {
+	case ok && !mined:
+		return r, nil
+	case ok:
+		cp := *r
+		r = &cp
+	case !mined:
+		return nil, nil
+	default:
+		r = &types.Receipt{Status: types.ReceiptStatusSuccessful, TxHash: hash, GasUsed: 21000, Logs: []*types.Log{}}
+	}
+	//和节点一样带上区块高度和节点返回的区块哈希
+	if r.BlockNumber == nil {
+		r.BlockNumber = new(big.Int).SetUint64(height)
+	}
+	header, ok := f.headers[height]
+	if !ok {
+		header = f.newHeader(height, common.Hash{}, 0)
+	}
+	r.BlockHash = f.reported(header.Hash())
+	return r, nil
+}
+
+// addPending 把 tx 放进交易池, 还没有回执
+func (f *fakeEth) addPending(tx *types.Transaction) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.pool == nil {
+		f.pool = make(map[common.Hash]*types.Transaction)
+	}
+	f.pool[tx.Hash()] = tx
+}
+
+func (f *fakeEth) dropPending(hash common.Hash) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.pool, hash)
+}
+
+func (f *fakeEth) setNonce(address common.Address, nonce uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.nonces == nil {
+		f.nonces = make(map[common.Address]uint64)
+	}
+	f.nonces[address] = nonce
+}
+
+func (f *fakeEth) GetTransactionByHash(hash common.Hash) (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tx, ok := f.pool[hash]
+	var height *hexutil.Uint64
+	for h, txs := range f.txs {
+		for _, t := range txs {
+			if t.Hash() == hash {
+				tx, ok = t, true
+				n := hexutil.Uint64(h)
+				height = &n
+			}
+		}
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := tx.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields["blockNumber"] = height
+	return fields, nil
+}
+
+func (f *fakeEth) GetTransactionCount(address common.Address, block fakeBlock) (hexutil.Uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return hexutil.Uint64(f.nonces[address]), nil
+}
+
+// fakeRevert 和 geth 一样带 revert 数据的 eth_call 错误
+type fakeRevert struct {
+	data []byte
+}
+
+func (e *fakeRevert) Error() string          { return "execution reverted" }
+func (e *fakeRevert) ErrorCode() int         { return 3 }
+func (e *fakeRevert) ErrorData() interface{} { return hexutil.Encode(e.data) }
+
+// setRevert 让对 to 的 eth_call 和 eth_estimateGas 带 data revert
+func (f *fakeEth) setRevert(to common.Address, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.reverts == nil {
+		f.reverts = make(map[common.Address][]byte)
+	}
+	f.reverts[to] = data
+}
+
+type fakeCall struct {
+	To   *common.Address `json:"to"`
+	Data hexutil.Bytes   `json:"data"`
+}
+
+func (f *fakeEth) Call(call fakeCall, block fakeBlock) (hexutil.Bytes, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.height(block); err != nil {
+		return nil, err
+	}
+	if call.To == nil {
+		return hexutil.Bytes{}, nil
+	}
+	return f.call(*call.To, call.Data)
+}
+
+func (f *fakeEth) call(to common.Address, data []byte) ([]byte, error) {
+	if len(data) >= 4 {
+		if f.calls == nil {
+			f.calls = make(map[string]int)
+		}
+		f.calls[string(data[:4])]++
+	}
+	if to == f.multicall && to != (common.Address{}) {
+		return f.aggregate(data)
+	}
+	if revert, ok := f.reverts[to]; ok {
+		return nil, &fakeRevert{data: revert}
+	}
+	if len(data) >= 4 {
+		if result, ok := f.methods[to][string(data[:4])]; ok {
+			return result, nil
+		}
+	}
+	return f.results[to], nil
+}
+
+// aggregate 模拟 Multicall3 的 aggregate3 和 getEthBalance
+func (f *fakeEth) aggregate(data []byte) ([]byte, error) {
+	method, err := multicallAbi.MethodById(data)
+	if err != nil {
+		return nil, &fakeRevert{}
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	if method.Name == "getEthBalance" {
+		return method.Outputs.Pack(f.balanceOf(args[0].(common.Address)))
+	}
+
+	calls := *abi.ConvertType(args[0], new([]multicallCall)).(*[]multicallCall)
+	results := make([]multicallResult, len(calls))
+	for i, c := range calls {
+		out, err := f.call(c.Target, c.CallData)
+		results[i] = multicallResult{Success: err == nil, ReturnData: out}
+		if err != nil && !c.AllowFailure {
+			return nil, &fakeRevert{}
+		}
+	}
+	return method.Outputs.Pack(results)
+}
+
+// callCount 对 method 的 eth_call 次数
+func (f *fakeEth) callCount(method abi.Method) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[string(method.ID)]
+}
+
+// setMethodResult 指定对 to 调用 method 的返回值, 优先于 setCallResult
+func (f *fakeEth) setMethodResult(to common.Address, method abi.Method, result []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.methods == nil {
+		f.methods = make(map[common.Address]map[string][]byte)
+	}
+	if f.methods[to] == nil {
+		f.methods[to] = make(map[string][]byte)
+	}
+	f.methods[to][string(method.ID)] = result
+}
+
+// setCallResult 指定对 to 的 eth_call 返回值
+func (f *fakeEth) setCallResult(to common.Address, result []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.results == nil {
+		f.results = make(map[common.Address][]byte)
+	}
+	f.results[to] = result
+}
+
+// SendRawTransaction 收到的交易马上打包进新的区块
+func (f *fakeEth) SendRawTransaction(raw hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return common.Hash{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, sent := range f.sent {
+		if sent.Hash() == tx.Hash() {
+			return common.Hash{}, errors.New("already known")
+		}
+	}
+	f.sent = append(f.sent, tx)
+	if f.poolSends {
+		if f.pool == nil {
+			f.pool = make(map[common.Hash]*types.Transaction)
+		}
+		f.pool[tx.Hash()] = tx
+		return tx.Hash(), nil
+	}
+	if f.txs == nil {
+		f.txs = make(map[uint64]types.Transactions)
+	}
+	if f.headers == nil {
+		f.headers = make(map[uint64]*types.Header)
+	}
+	height := f.head + 1
+	f.txs[height] = append(f.txs[height], tx)
+	var parent common.Hash
+	if prev, ok := f.headers[f.head]; ok {
+		parent = prev.Hash()
+	}
+	f.headers[height] = f.newHeader(height, parent, 0)
+	f.head = height
+	return tx.Hash(), nil
+}
+
+func (f *fakeEth) EstimateGas(call fakeCall) (hexutil.Uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if call.To != nil {
+		if data, ok := f.reverts[*call.To]; ok {
+			return 0, &fakeRevert{data: data}
+		}
+	}
+	return 21000, nil
+}
+
+func (f *fakeEth) addLog(l types.Log) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logs = append(f.logs, l)
+}
+
+func (f *fakeEth) setHead(head uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.head = head
+}
+
+type fakeFilter struct {
+	FromBlock string           `json:"fromBlock"`
+	ToBlock   string           `json:"toBlock"`
+	Address   []common.Address `json:"address"`
+	Topics    [][]common.Hash  `json:"topics"`
+}
+
+func (f *fakeEth) GetLogs(filter fakeFilter) ([]types.Log, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logCalls++
+
+	blockOf := func(s string) (uint64, error) {
+		if s == "" || s == "latest" || s == "pending" {
+			return f.head, nil
+		}
+		return strconv.ParseUint(s, 0, 64)
+	}
+	from, err := blockOf(filter.FromBlock)
+	if err != nil {
+		return nil, err
+	}
+	to, err := blockOf(filter.ToBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := func(h common.Hash, set []common.Hash) bool {
+		if len(set) == 0 {
+			return true
+		}
+		for _, s := range set {
+			if s == h {
+				return true
+			}
+		}
+		return false
+	}
+
+	logs := []types.Log{}
+	for _, l := range f.logs {
+		if l.BlockNumber < from || l.BlockNumber > to {
+			continue
+		}
+		if len(filter.Address) > 0 {
+			found := false
+			for _, a := range filter.Address {
+				found = found || a == l.Address
+			}
+			if !found {
+				continue
+			}
+		}
+		ok := len(filter.Topics) <= len(l.Topics)
+		for i := 0; ok && i < len(filter.Topics); i++ {
+			ok = matches(l.Topics[i], filter.Topics[i])
+		}
+		if ok {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
+func (f *fakeEth) hashOf(height uint64) common.Hash {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reported(f.headers[height].Hash())
+}
+
+// reported 节点返回给客户端的区块哈希
+func (f *fakeEth) reported(hash common.Hash) common.Hash {
+	if !f.foreignHashes || hash == (common.Hash{}) {
+		return hash
+	}
+	return crypto.Keccak256Hash([]byte("tendermint"), hash.Bytes())
+}
+
+func (f *fakeEth) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	ch := make(chan *types.Header, 16)
+	f.mu.Lock()
+	if f.headSubs == nil {
+		f.headSubs = make(map[chan *types.Header]bool)
+	}
+	f.headSubs[ch] = true
+	f.mu.Unlock()
+
+	go func() {
+		defer func() {
+			f.mu.Lock()
+			delete(f.headSubs, ch)
+			f.mu.Unlock()
+		}()
+		for {
+			select {
+			case h := <-ch:
+				notifier.Notify(sub.ID, h)
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func (f *fakeEth) NewPendingTransactions(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	ch := make(chan common.Hash, 16)
+	f.mu.Lock()
+	if f.pendingSubs == nil {
+		f.pendingSubs = make(map[chan common.Hash]bool)
+	}
+	f.pendingSubs[ch] = true
+	f.mu.Unlock()
+
+	go func() {
+		defer func() {
+			f.mu.Lock()
+			delete(f.pendingSubs, ch)
+			f.mu.Unlock()
+		}()
+		for {
+			select {
+			case h := <-ch:
+				notifier.Notify(sub.ID, h)
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// Logs 推送所有 pushLog 的日志, 不按条件过滤, 由客户端再筛
+func (f *fakeEth) Logs(ctx context.Context, filter fakeFilter) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	ch := make(chan types.Log, 16)
+	f.mu.Lock()
+	if f.logSubs == nil {
+		f.logSubs = make(map[chan types.Log]bool)
+	}
+	f.logSubs[ch] = true
+	f.mu.Unlock()
+
+	go func() {
+		defer func() {
+			f.mu.Lock()
+			delete(f.logSubs, ch)
+			f.mu.Unlock()
+		}()
+		for {
+			select {
+			case l := <-ch:
+				notifier.Notify(sub.ID, l)
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// pushLog 记录日志并推给订阅者
+func (f *fakeEth) pushLog(l types.Log) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.logs = append(f.logs, l)
+	for ch := range f.logSubs {
+		ch <- l
+	}
+}
+
+func (f *fakeEth) logSubscribers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.logSubs)
+}
+
+// pushHead 把 height 的区块头推给订阅者
+func (f *fakeEth) pushHead(height uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	header, ok := f.headers[height]
+	if !ok {
+		header = f.newHeader(height, common.Hash{}, 0)
+	}
+	for ch := range f.headSubs {
+		ch <- header
+	}
+}
+
+func (f *fakeEth) pushPending(hash common.Hash) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for ch := range f.pendingSubs {
+		ch <- hash
+	}
+}
+
+func (f *fakeEth) subscribers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.headSubs)
+}
+
+// testNode 一个可以模拟宕机的 http 节点
+type testNode struct {
+	eth   *fakeEth
+	url   string
+	wsURL string
+	mu    sync.Mutex
+	down  bool
+
+	dropWs func()
+}
+
+func (n *testNode) setDown(down bool) {
+	n.mu.Lock()
+	n.down = down
+	n.mu.Unlock()
+}
+
+// takeDropSend 这次广播是否要断开连接
+func (f *fakeEth) takeDropSend() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.dropSends == 0 {
+		return false
+	}
+	f.dropSends--
+	return true
+}
+
+func newTestNode(t *testing.T) *testNode {
+	return newTestNodeOn(t, &fakeEth{head: 100, gasPrice: big.NewInt(1e9), tip: big.NewInt(2e9)})
+}
+
+// newTestNodeOn 用已有的 fakeEth 再开一个节点, 模拟连着同一条链的多个节点
+func newTestNodeOn(t *testing.T, eth *fakeEth) *testNode {
+	node := &testNode{eth: eth}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", node.eth); err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		node.mu.Lock()
+		down := node.down
+		node.mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if bytes.Contains(body, []byte("eth_sendRawTransaction")) && node.eth.takeDropSend() {
+			server.ServeHTTP(httptest.NewRecorder(), r)
+			if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	//websocket 用单独的 server, dropWs 可以断开所有订阅
+	wsServer := rpc.NewServer()
+	if err := wsServer.RegisterName("eth", node.eth); err != nil {
+		t.Fatal(err)
+	}
+	ws := httptest.NewServer(wsServer.WebsocketHandler([]string{"*"}))
+	var dropOnce sync.Once
+	node.dropWs = func() {
+		dropOnce.Do(func() {
+			wsServer.Stop()
+			ws.Close()
+		})
+	}
+
+	t.Cleanup(func() {
+		hs.Close()
+		server.Stop()
+		node.dropWs()
+	})
+
+	node.url = hs.URL
+	node.wsURL = "ws" + strings.TrimPrefix(ws.URL, "http")
+	return node
+}
+
+// newTestTx 用随机私钥签一笔转账
+func newTestTx(t *testing.T, nonce uint64, to common.Address, value *big.Int) *types.Transaction {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := types.NewTx(&types.LegacyTx{Nonce: nonce, GasPrice: big.NewInt(1e9), Gas: 21000, To: &to, Value: value})
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(big.NewInt(1337)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
@@ -2,15 +2,12 @@ package blx
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"math/big"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,13 +15,10 @@ import (
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rlp"
-	"github.com/ethereum/go-ethereum/rpc"
-	"github.com/sirupsen/logrus"
 	"github.com/zhengjianfeng1103/FbSdk/log"
 )
 
@@ -39,198 +33,144 @@ const MaxRetryTimeDurationSeconds = 1
 
 var MainCoinDecimal = big.NewFloat(math.Pow(10, 18))
 
-var PoolClosedError = NewJkError("请求池子用尽")
-var BalanceLessGasError = NewJkError("交易费不足")
-var BalanceLessGasAddAmountError = NewJkError("余额小于交易费+转账数量")
-var BalanceLessAmountError = NewJkError("转账数量不足")
-var PrivateKeyError = NewJkError("错误的私钥")
-var AmountError = NewJkError("金额有误")
-var ReadTransactionTimeOutError = NewJkError("读取交易信息失败")
-var SendTransactionFailedError = NewJkError("交易失败")
-var ContractNotEmpty = NewJkError("合约地址不能为空")
-var NotAnHexAddress = NewJkError("不是合法0x地址")
-var NonceNotEmpty = NewJkError("交易序号不能为空")
-var NonceToSmall = NewJkError("交易序号太小")
-
-type Jk struct {
-	cons    chan *ethclient.Client
-	factory func() (*ethclient.Client, error)
-	m       sync.Mutex
-	rw      sync.Mutex
-	closed  bool
+// 带上下文返回的错误是 *ContextError, 和下面的变量不再 ==, 要用 errors.Is 比较, 见 JkError
+var PoolClosedError = newCodedError(CodePoolClosed)
+var NoHealthyConnectionError = newCodedError(CodeNoHealthyConnection)
+var BalanceLessGasError = newCodedError(CodeBalanceLessGas)
+var BalanceLessGasAddAmountError = newCodedError(CodeBalanceLessGasAddAmount)
+var BalanceLessAmountError = newCodedError(CodeBalanceLessAmount)
+var PrivateKeyError = newCodedError(CodePrivateKey)
+var AmountError = newCodedError(CodeAmount)
+var ReadTransactionTimeOutError = newCodedError(CodeReadTransactionTimeOut)
+var SendTransactionFailedError = newCodedError(CodeSendTransactionFailed)
+var ContractNotEmpty = newCodedError(CodeContractNotEmpty)
+var NotAnHexAddress = newCodedError(CodeNotAnHexAddress)
+var NonceNotEmpty = newCodedError(CodeNonceNotEmpty)
+var NonceToSmall = newCodedError(CodeNonceTooSmall)
+
+func (j *Jk) Network() *NetworkConfig {
+	return j.net
 }
 
-func NewJk(size int, net string, level logrus.Level) *Jk {
-	log.Init(level)
-	cons := make(chan *ethclient.Client, size)
+// ChainId returns the configured chain id, asking the node when the profile leaves it empty.
+func (j *Jk) ChainId(ctx context.Context) (*big.Int, error) {
+	j.rw.Lock()
+	defer j.rw.Unlock()
 
-	if size == 0 {
-		size = 3
+	if j.chainID != nil {
+		return j.chainID, nil
 	}
 
-	for i := 0; i < size; i++ {
-
-		timeoutC, fn := context.WithTimeout(context.Background(), 10*time.Second)
-		fn()
-		connect, err := rpc.DialContext(timeoutC, net)
-		if err != nil {
-			log.Log.Error("connect eth mainNet error", err)
-			continue
-		}
-
-		ec := ethclient.NewClient(connect)
-		if err != nil {
-			log.Log.Debug(fmt.Sprintf("init eth client err: %v", err))
-		}
-		cons <- ec
+	if j.net.ChainId != 0 {
+		j.chainID = big.NewInt(j.net.ChainId)
+		return j.chainID, nil
 	}
 
-	return &Jk{cons, func() (*ethclient.Client, error) {
-
-		timeoutC, fn := context.WithTimeout(context.Background(), 10*time.Second)
-		fn()
-		connect, err := rpc.DialContext(timeoutC, MainNet)
-		if err != nil {
-			log.Log.Error("connect eth mainNet error", err)
-			return nil, err
-		}
+	var id *big.Int
+	err := j.withClient(ctx, func(client *ethclient.Client) (err error) {
+		id, err = client.ChainID(ctx)
+		return
+	})
+	if err != nil {
+		log.Log.Error("get chain id: ", err)
+		return nil, err
+	}
 
-		ec := ethclient.NewClient(connect)
-		if err != nil {
-			log.Log.Debug(fmt.Sprintf("init eth client err: %v", err))
-			return nil, err
-		}
-		return ec, nil
-	},
-		sync.Mutex{},
-		sync.Mutex{},
-		false}
+	j.chainID = id
+	return id, nil
 }
 
-func (j *Jk) Acquire() (*ethclient.Client, error) {
-	select {
-	case r, ok := <-j.cons:
-		if !ok {
-			return nil, PoolClosedError
-		}
-		log.Log.Debug("从池子里获取连接了")
-		return r, nil
-	default:
-		log.Log.Debug("需要新建资源了")
-		return j.factory()
+// sendTransaction 广播失败是节点问题时, 换一个节点重发同一笔签名交易.
+// 第一个节点可能已经收下交易才断开, 重发时节点说已经有这笔交易也算成功
+func (j *Jk) sendTransaction(ctx context.Context, client *ethclient.Client, tx *types.Transaction) error {
+	err := client.SendTransaction(ctx, tx)
+	if !isEndpointError(err) {
+		return err
 	}
-}
 
-func (j *Jk) Release(r *ethclient.Client) {
-	//保证该操作和Close方法的操作是安全的
-	j.m.Lock()
-	defer j.m.Unlock()
+	log.Log.Warn("broadcast tx ", tx.Hash(), " failed, retry on another endpoint: ", err)
+	return j.withClient(ctx, func(client *ethclient.Client) error {
+		err := client.SendTransaction(ctx, tx)
+		if isAlreadyKnown(err) {
+			log.Log.Info("tx ", tx.Hash(), " already known by the node")
+			return nil
+		}
+		return err
+	})
+}
 
-	//资源池都关闭了，就省这一个没有释放的资源了，释放即可
-	if j.closed {
-		r.Close()
-		return
+// isAlreadyKnown 节点的交易池里已经有这笔交易
+func isAlreadyKnown(err error) bool {
+	if err == nil {
+		return false
 	}
-
-	select {
-	case j.cons <- r:
-		log.Log.Debug("资源释放到池子里了")
-	default:
-		log.Log.Debug("资源池满了，释放这个资源吧")
-		r.Close()
+	if errors.Is(err, core.ErrAlreadyKnown) {
+		return true
 	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "already known") || strings.Contains(msg, "known transaction")
 }
 
-func (j *Jk) Close() {
-	j.m.Lock()
-	defer j.m.Unlock()
-
-	if j.closed {
+// GetBalanceOfBig returns the native balance in base units, at the latest block unless at is given.
+func (j *Jk) GetBalanceOfBig(ctx context.Context, address string, at ...BlockRef) (balance *big.Int, err error) {
+	err = j.withClient(ctx, func(client *ethclient.Client) (err error) {
+		balance, err = j.balanceAt(ctx, client, common.HexToAddress(address), blockOf(at))
 		return
-	}
-
-	j.closed = true
-
-	//关闭通道，不让写入了
-	close(j.cons)
-
-	//关闭通道里的资源
-	for r := range j.cons {
-		r.Close()
-	}
+	})
+	return
 }
 
-func (j *Jk) GetBalanceOf(ctx context.Context, address string) (balance float64, err error) {
-	client, err := j.Acquire()
-	if err != nil {
-		return 0, err
-	}
-	defer j.Release(client)
-
-	bc, err := client.BalanceAt(ctx, common.HexToAddress(address), nil)
+// GetBalanceOf returns the native balance as float64, which loses precision on large amounts,
+// use GetBalanceOfBig for accounting.
+func (j *Jk) GetBalanceOf(ctx context.Context, address string, at ...BlockRef) (balance float64, err error) {
+	bc, err := j.GetBalanceOfBig(ctx, address, at...)
 	if err != nil {
 		return
 	}
 
-	flb, success := new(big.Float).SetString(bc.String())
-	if !success {
-		return 0, errors.New("balance can not covert to big float")
-	}
-
-	bcf, _ := new(big.Float).Quo(flb, MainCoinDecimal).Float64()
+	bcf, _ := new(big.Float).Quo(new(big.Float).SetInt(bc), j.net.coinDecimal()).Float64()
 	return bcf, nil
 }
 
 func (j *Jk) GetPendingNonce(ctx context.Context, address string) (nonce uint64, err error) {
-	client, err := j.Acquire()
-	if err != nil {
-		return 0, err
-	}
-	defer j.Release(client)
-
-	at, err := client.PendingNonceAt(ctx, common.HexToAddress(address))
+	err = j.withClient(ctx, func(client *ethclient.Client) (err error) {
+		nonce, err = client.PendingNonceAt(ctx, common.HexToAddress(address))
+		return
+	})
 	if err != nil {
 		return 0, err
 	}
-	return at, nil
+	return nonce, nil
 }
 
-func (j *Jk) GetBalanceOfContract(ctx context.Context, address string, contractAddr string) (balance float64, decimals float64, err error) {
-	client, err := j.Acquire()
-	if err != nil {
-		return
-	}
-	defer j.Release(client)
-
+// GetBalanceOfContractBig returns the token balance in base units with the token decimals, the
+// decimals come from the token cache. The balance is read at the latest block unless at is given.
+func (j *Jk) GetBalanceOfContractBig(ctx context.Context, address string, contractAddr string, at ...BlockRef) (balance *big.Int, decimals uint8, err error) {
 	if contractAddr == "" {
-		return 0, 0, ContractNotEmpty
+		return nil, 0, ContractNotEmpty
 	}
 
 	from := common.HexToAddress(address)
 	to := common.HexToAddress(contractAddr)
 
-	gasPrice, err := client.SuggestGasPrice(context.Background())
-	log.Log.Debug("gasPrice", gasPrice)
-
-	erc20Abi, err := abi.JSON(strings.NewReader(AbiErc20))
-	if err != nil {
-		return
-	}
-
+	erc20Abi := j.tokens.ABI()
 	input, err := erc20Abi.Pack("balanceOf", from)
 	if err != nil {
 		return
 	}
 
 	msg := ethereum.CallMsg{
-		From:     from,
-		To:       &to,
-		Data:     input,
-		GasPrice: gasPrice,
-		Gas:      10000,
+		From: from,
+		To:   &to,
+		Data: input,
+		Gas:  10000,
 	}
 
-	result, err := client.CallContract(ctx, msg, nil)
+	var result []byte
+	err = j.withClient(ctx, func(client *ethclient.Client) (err error) {
+		result, err = j.callContractAt(ctx, client, msg, blockOf(at))
+		return
+	})
 	if err != nil {
 		return
 	}
@@ -241,35 +181,26 @@ func (j *Jk) GetBalanceOfContract(ctx context.Context, address string, contractA
 		return
 	}
 
-	//call for decimals
-	inputDecimals, err := erc20Abi.Pack("decimals")
+	decimals, err = j.tokens.Decimals(ctx, to)
 	if err != nil {
 		return
 	}
-	msgDecimals := ethereum.CallMsg{
-		From: from,
-		To:   &to,
-		Data: inputDecimals,
-	}
 
-	resultDecimals, err := client.CallContract(ctx, msgDecimals, nil)
-	if err != nil {
-		return
-	}
+	log.Log.Debug("call decimals of result: ", decimals)
+	log.Log.Debug("call balance of result: ", unpack[0])
 
-	//call for balanceOf
-	unpackDecimals, err := erc20Abi.Unpack("decimals", resultDecimals)
+	return unpack[0].(*big.Int), decimals, nil
+}
+
+// GetBalanceOfContract returns the token balance as float64 and 10^decimals,
+// use GetBalanceOfContractBig for accounting.
+func (j *Jk) GetBalanceOfContract(ctx context.Context, address string, contractAddr string, at ...BlockRef) (balance float64, decimals float64, err error) {
+	bc, bDecimals, err := j.GetBalanceOfContractBig(ctx, address, contractAddr, at...)
 	if err != nil {
 		return
 	}
 
-	log.Log.Debug("call decimals of result: ", unpackDecimals[0])
-	log.Log.Debug("call balance of result: ", unpack[0])
-
-	bc := unpack[0].(*big.Int)
 	fc := new(big.Float).SetInt(bc)
-
-	bDecimals := unpackDecimals[0].(uint8)
 	fDecimals := new(big.Float).SetFloat64(math.Pow10(int(bDecimals)))
 	fDecimalsF, _ := fDecimals.Float64()
 
@@ -277,164 +208,52 @@ func (j *Jk) GetBalanceOfContract(ctx context.Context, address string, contractA
 	return f, fDecimalsF, nil
 }
 
-func (j *Jk) GetSymbolOfContract(ctx context.Context, contractAddr string) (symbol string, err error) {
-	client, err := j.Acquire()
-	if err != nil {
-		return
-	}
-	defer j.Release(client)
-
+// GetDecimalsOfContract returns the token decimals from the token cache.
+func (j *Jk) GetDecimalsOfContract(ctx context.Context, contractAddr string) (decimals uint8, err error) {
 	if contractAddr == "" {
-		return "", ContractNotEmpty
-	}
-
-	to := common.HexToAddress(contractAddr)
-
-	gasPrice, err := client.SuggestGasPrice(context.Background())
-	log.Log.Debug("gasPrice", gasPrice)
-
-	erc20Abi, err := abi.JSON(strings.NewReader(AbiErc20))
-	if err != nil {
-		return
-	}
-
-	input, err := erc20Abi.Pack("symbol")
-	if err != nil {
-		return
-	}
-
-	msg := ethereum.CallMsg{
-		To:   &to,
-		Data: input,
+		return 0, ContractNotEmpty
 	}
+	return j.tokens.Decimals(ctx, common.HexToAddress(contractAddr))
+}
 
-	result, err := client.CallContract(ctx, msg, nil)
-	if err != nil {
-		return
-	}
-
-	//call for symbol
-	unpack, err := erc20Abi.Unpack("symbol", result)
-	if err != nil {
-		return
-	}
-
-	log.Log.Debug("call symbol of result: ", unpack[0])
-
-	bc := unpack[0].(string)
-	return bc, nil
+// ParseCoinAmount converts a native coin amount to base units.
+func (j *Jk) ParseCoinAmount(amount Decimal) (*big.Int, error) {
+	return amount.BaseUnits(j.net.Decimals)
 }
 
-func (j *Jk) SendSync(ctx context.Context, senderPrivate string, receive string, amount float64) (hash string, err error) {
-	if !common.IsHexAddress(receive) {
-		return "", NotAnHexAddress
-	}
-	client, err := j.Acquire()
+// ParseTokenAmount converts a token amount to base units with the token's decimals.
+func (j *Jk) ParseTokenAmount(ctx context.Context, contractAddr string, amount Decimal) (*big.Int, error) {
+	decimals, err := j.GetDecimalsOfContract(ctx, contractAddr)
 	if err != nil {
-		return
-	}
-	defer j.Release(client)
-
-	if strings.HasPrefix(senderPrivate, "0x") {
-		senderPrivate = senderPrivate[2:]
+		return nil, err
 	}
+	return amount.BaseUnits(decimals)
+}
 
-	privateKey, err := crypto.HexToECDSA(senderPrivate)
+// parseTokenFloat 旧 float64 接口的代币数量, 按代币精度四舍五入
+func (j *Jk) parseTokenFloat(ctx context.Context, contractAddr string, amount float64) (*big.Int, error) {
+	decimals, err := j.GetDecimalsOfContract(ctx, contractAddr)
 	if err != nil {
-		log.Log.Error(fmt.Sprintf("recover key err: %v", err))
-		return "", PrivateKeyError
-	}
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		log.Log.Error("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
-		return "", PrivateKeyError
-	}
-
-	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
-	log.Log.Debug("Public Key: ", hexutil.Encode(publicKeyBytes))
-
-	address := crypto.PubkeyToAddress(*publicKeyECDSA).Hex()
-	log.Log.Debug("Address: ", address)
-
-	from := common.HexToAddress(address)
-	to := common.HexToAddress(receive)
-
-	strAmount := fmt.Sprintf("%f", amount)
-	f, success := new(big.Float).SetString(strAmount)
-	if !success {
-		return "", AmountError
-	}
-	fCoins := new(big.Float).Mul(f, MainCoinDecimal)
-	coins, _ := fCoins.Int(nil)
-
-	log.Log.Debug("from: ", from, "to: ", to, "coins: ", coins)
-
-	gasPrice, err := client.SuggestGasPrice(ctx)
-	log.Log.Debug("gasPrice: ", gasPrice)
-
-	balance, err := client.BalanceAt(ctx, from, nil)
-	log.Log.Debug("balance: ", balance)
-
-	pendingNonce, err := client.PendingNonceAt(ctx, from)
-	log.Log.Debug("pendingNonce: ", pendingNonce)
-
-	gasLimit := uint64(30000)
-
-	gas := new(big.Int).Mul(gasPrice, big.NewInt(int64(gasLimit)))
-	if balance.Cmp(gas) <= 0 {
-		return "", BalanceLessGasError
-	}
-
-	log.Log.Debug("gas: ", gas)
-
-	if balance.Cmp(new(big.Int).Add(gas, coins)) <= 0 {
-		return "", BalanceLessGasAddAmountError
+		return nil, err
 	}
+	return decimalFromFloat(amount, decimals).BaseUnits(decimals)
+}
 
-	unsignedTx := types.NewTransaction(pendingNonce, to, coins, gasLimit, gasPrice, []byte{})
-	signedTx, _ := types.SignTx(unsignedTx, types.NewEIP155Signer(big.NewInt(MainNetChainId)), privateKey)
-
-	err = client.SendTransaction(ctx, signedTx)
+// FormatTokenAmount formats token base units with the token's decimals.
+func (j *Jk) FormatTokenAmount(ctx context.Context, contractAddr string, amount *big.Int) (Decimal, error) {
+	decimals, err := j.GetDecimalsOfContract(ctx, contractAddr)
 	if err != nil {
-		log.Log.Error("send transaction", err)
 		return "", err
 	}
+	return NewDecimal(amount, decimals), nil
+}
 
-	txHash := signedTx.Hash()
-	log.Log.Debug("sendTx txHash:", txHash)
-
-	hash = txHash.Hex()
-	retryTimes := 0
-	for {
-		select {
-		case <-time.NewTimer(MaxRetryTimeDurationSeconds * time.Second).C:
-			if retryTimes >= MaxRetrySync {
-				return hash, nil
-			}
-
-			receipt, err := client.TransactionReceipt(ctx, txHash)
-			if err != nil {
-				log.Log.Error("get transaction receipt: ", err)
-				retryTimes++
-				continue
-			}
-
-			if receipt.Status == types.ReceiptStatusSuccessful && receipt.BlockNumber != nil {
-				log.Log.Debug("get transaction success status", " Tx In BlockNumber: ", receipt.BlockNumber, " GasUse: ", receipt.GasUsed, " Logs: ", receipt.Logs)
-				return hash, nil
-			}
-
-			if receipt.Status == types.ReceiptStatusFailed {
-				log.Log.Error("get transaction failed status")
-				return "", SendTransactionFailedError
-			}
-
-		case <-ctx.Done():
-			log.Log.Error("get transaction time out context")
-			return "", ReadTransactionTimeOutError
-		}
+// GetSymbolOfContract returns the token symbol from the token cache.
+func (j *Jk) GetSymbolOfContract(ctx context.Context, contractAddr string) (symbol string, err error) {
+	if contractAddr == "" {
+		return "", ContractNotEmpty
 	}
+	return j.tokens.Symbol(ctx, common.HexToAddress(contractAddr))
 }
 
 // SendRawTx rawTx hexString no 0x
@@ -443,7 +262,7 @@ func (j *Jk) SendRawTx(ctx context.Context, rawTx string) (hash string, txResult
 	if err != nil {
 		return
 	}
-	defer j.Release(client)
+	defer func() { j.releaseWithError(client, err) }()
 
 	var tx *types.Transaction
 	rawTxBytes, err := hex.DecodeString(rawTx)
@@ -452,610 +271,215 @@ func (j *Jk) SendRawTx(ctx context.Context, rawTx string) (hash string, txResult
 	}
 	err = rlp.DecodeBytes(rawTxBytes, &tx)
 	if err != nil {
-		return "", nil, err
-	}
-
-	hash = tx.Hash().String()
-	log.Log.Debug("sendRawTx: ", tx.Hash().String())
-
-	err = client.SendTransaction(context.Background(), tx)
-	if err != nil {
-		return hash, tx, err
-	}
-
-	retryTimes := 0
-	for {
-		select {
-		case <-time.NewTimer(MaxRetryTimeDurationSeconds * time.Second).C:
-			if retryTimes >= MaxRetrySync {
-				return hash, tx, nil
-			}
-
-			receipt, err := j.GetTransactionReceiptByHash(ctx, hash)
-			if err != nil {
-				log.Log.Error("get transaction receipt: ", err)
-				retryTimes++
-				continue
-			}
-
-			if receipt.Status == types.ReceiptStatusSuccessful && receipt.BlockNumber != nil {
-				log.Log.Debug("get transaction success status", " Tx In BlockNumber: ", receipt.BlockNumber, " GasUse: ", receipt.GasUsed, " Logs: ", receipt.Logs)
-				return hash, tx, nil
-			}
-
-			if receipt.Status == types.ReceiptStatusFailed {
-				log.Log.Error("get transaction failed status")
-				return hash, tx, SendTransactionFailedError
-			}
-
-		case <-ctx.Done():
-			log.Log.Error("get transaction time out context")
-			return hash, tx, ReadTransactionTimeOutError
-		}
-	}
-}
-
-func (j *Jk) SendAsync(ctx context.Context, senderPrivate string, receive string, amount float64, nonce uint64) (hash string, err error) {
-	if !common.IsHexAddress(receive) {
-		return "", NotAnHexAddress
-	}
-
-	client, err := j.Acquire()
-	if err != nil {
-		return
-	}
-	defer j.Release(client)
-
-	if strings.HasPrefix(senderPrivate, "0x") {
-		senderPrivate = senderPrivate[2:]
-	}
-	privateKey, err := crypto.HexToECDSA(senderPrivate)
-	if err != nil {
-		log.Log.Error(fmt.Sprintf("recover key err: %v", err))
-		return "", PrivateKeyError
-	}
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		log.Log.Error("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
-		return "", PrivateKeyError
-	}
-
-	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
-	log.Log.Debug("Public Key: ", hexutil.Encode(publicKeyBytes))
-
-	address := crypto.PubkeyToAddress(*publicKeyECDSA).Hex()
-	log.Log.Debug("Address: ", address)
-	from := common.HexToAddress(address)
-	to := common.HexToAddress(receive)
-
-	strAmount := fmt.Sprintf("%f", amount)
-	f, success := new(big.Float).SetString(strAmount)
-	if !success {
-		return "", AmountError
-	}
-	fCoins := new(big.Float).Mul(f, MainCoinDecimal)
-	coins, _ := fCoins.Int(nil)
-
-	log.Log.Debug("from: ", from, "to: ", to, "coins: ", coins)
-
-	gasPrice, err := client.SuggestGasPrice(ctx)
-	if err != nil {
-		log.Log.Error("get balance err: ", err)
-		return "", err
-	}
-	log.Log.Debug("gasPrice: ", gasPrice)
-
-	balance, err := client.BalanceAt(ctx, from, nil)
-	if err != nil {
-		log.Log.Error("get balance err: ", err)
-		return "", err
-	}
-	log.Log.Debug("balance: ", balance)
-
-	var pendingNonce uint64
-	if nonce == 0 {
-		pendingNonce, err = client.PendingNonceAt(ctx, from)
-		if err != nil {
-			log.Log.Error("get pendingNonce err: ", err)
-			return "", err
-		}
-	} else {
-		pendingNonce = nonce
-	}
-	log.Log.Debug("pendingNonce: ", pendingNonce)
-
-	gasLimit := uint64(30000)
-	gas := new(big.Int).Mul(gasPrice, big.NewInt(int64(gasLimit)))
-	if balance.Cmp(gas) <= 0 {
-		return "", BalanceLessGasError
-	}
-
-	log.Log.Debug("gas: ", gas)
-
-	if balance.Cmp(new(big.Int).Add(gas, coins)) <= 0 {
-		return "", BalanceLessGasAddAmountError
-	}
-
-	unsignedTx := types.NewTransaction(pendingNonce, to, coins, gasLimit, gasPrice, []byte{})
-	signedTx, _ := types.SignTx(unsignedTx, types.NewEIP155Signer(big.NewInt(MainNetChainId)), privateKey)
-
-	err = client.SendTransaction(ctx, signedTx)
-	if err != nil {
-		log.Log.Error("send transaction", err)
-		return "", err
-	}
-
-	txHash := signedTx.Hash()
-	log.Log.Debug("sendTx txHash:", txHash)
-
-	hash = txHash.Hex()
-	return
-}
-
-func (j *Jk) SendContractSync(ctx context.Context, senderPrivate string, receive string, amount float64, contractAddr string) (hash string, err error) {
-	if !common.IsHexAddress(receive) {
-		return "", NotAnHexAddress
-	}
-
-	if contractAddr == "" {
-		return "", ContractNotEmpty
-	}
-
-	client, err := j.Acquire()
-	if err != nil {
-		return
-	}
-	defer j.Release(client)
-
-	if strings.HasPrefix(senderPrivate, "0x") {
-		senderPrivate = senderPrivate[2:]
-	}
-
-	privateKey, err := crypto.HexToECDSA(senderPrivate)
-	if err != nil {
-		log.Log.Error(fmt.Sprintf("recover key err: %v", err))
-		return "", PrivateKeyError
-	}
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		log.Log.Error("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
-		return "", PrivateKeyError
-	}
-
-	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
-	log.Log.Debug("Public Key: ", hexutil.Encode(publicKeyBytes))
-
-	address := crypto.PubkeyToAddress(*publicKeyECDSA).Hex()
-	log.Log.Debug("Address: ", address)
-
-	balanceContract, decimals, err := j.GetBalanceOfContract(ctx, address, contractAddr)
-	if err != nil {
-		return "", err
-	}
-
-	if balanceContract < amount {
-		return "", BalanceLessAmountError
-	}
-
-	from := common.HexToAddress(address)
-	to := common.HexToAddress(receive)
-	contract := common.HexToAddress(contractAddr)
-
-	strAmount := fmt.Sprintf("%f", amount)
-	f, success := new(big.Float).SetString(strAmount)
-	if !success {
-		return "", AmountError
-	}
-
-	fCoins := new(big.Float).Mul(f, big.NewFloat(decimals))
-	coins, _ := fCoins.Int(nil)
-
-	log.Log.Debug("from: ", from, " to: ", to, " contractAddr: ", contractAddr, " coins: ", coins)
-
-	gasPrice, err := client.SuggestGasPrice(ctx)
-	log.Log.Debug("gasPrice: ", gasPrice)
-
-	balance, err := client.BalanceAt(ctx, from, nil)
-	log.Log.Debug("balance: ", balance)
-
-	pendingNonce, err := client.PendingNonceAt(ctx, from)
-	log.Log.Debug("pendingNonce: ", pendingNonce)
-
-	erc20Abi, err := abi.JSON(strings.NewReader(AbiErc20))
-	if err != nil {
-		return
-	}
-
-	input, err := erc20Abi.Pack("transfer", to, coins)
-	if err != nil {
-		return
-	}
-
-	msgGas := ethereum.CallMsg{
-		From: from,
-		To:   &contract,
-		Data: input,
-	}
-
-	gasLimit, err := client.EstimateGas(ctx, msgGas)
-	log.Log.Debug("gasLimit: ", gasLimit)
-
-	if err != nil {
-		return "", err
-	}
-
-	gas := new(big.Int).Mul(gasPrice, big.NewInt(int64(gasLimit)))
-	log.Log.Debug("gas: ", gas)
-
-	if balance.Cmp(gas) <= 0 {
-		return "", BalanceLessGasError
-	}
-
-	unsignedTx := types.NewTransaction(pendingNonce, contract, big.NewInt(0), gasLimit, gasPrice, input)
-	signedTx, _ := types.SignTx(unsignedTx, types.NewEIP155Signer(big.NewInt(MainNetChainId)), privateKey)
-
-	err = client.SendTransaction(ctx, signedTx)
-	if err != nil {
-		log.Log.Error("send transaction", err)
-		return "", err
-	}
-
-	txHash := signedTx.Hash()
-	log.Log.Debug("sendTx txHash:", txHash)
-
-	hash = txHash.Hex()
-	retryTimes := 0
-	for {
-		select {
-		case <-time.NewTimer(MaxRetryTimeDurationSeconds * time.Second).C:
-			if retryTimes >= MaxRetrySync {
-				return hash, nil
-			}
-
-			receipt, err := client.TransactionReceipt(ctx, txHash)
-			if err != nil {
-				log.Log.Error("get transaction receipt: ", err)
-				retryTimes++
-				continue
-			}
-
-			if receipt.Status == types.ReceiptStatusSuccessful && receipt.BlockNumber != nil {
-				log.Log.Debug("get transaction success status", " Tx In BlockNumber: ", receipt.BlockNumber, " GasUse: ", receipt.GasUsed, " Logs: ", receipt.Logs)
-				return hash, nil
-			}
-
-			if receipt.Status == types.ReceiptStatusFailed {
-				log.Log.Error("get transaction failed status")
-				return "", SendTransactionFailedError
-			}
-
-		case <-ctx.Done():
-			log.Log.Error("get transaction time out context")
-			return "", ReadTransactionTimeOutError
-		}
-	}
-}
-
-func (j *Jk) SendContractSyncWithNonce(ctx context.Context, senderPrivate string, receive string, amount float64, contractAddr string, pendingNonce uint64) (hash string, err error) {
-	if !common.IsHexAddress(receive) {
-		return "", NotAnHexAddress
-	}
-
-	if contractAddr == "" {
-		return "", ContractNotEmpty
+		return "", nil, err
 	}
 
-	if pendingNonce == 0 {
-		return "", NonceNotEmpty
-	}
+	hash = tx.Hash().String()
+	log.Log.Debug("sendRawTx: ", tx.Hash().String())
 
-	client, err := j.Acquire()
+	err = j.sendTransaction(ctx, client, tx)
 	if err != nil {
-		return
+		return hash, tx, err
 	}
-	defer j.Release(client)
 
-	if strings.HasPrefix(senderPrivate, "0x") {
-		senderPrivate = senderPrivate[2:]
-	}
+	err = j.waitReceipt(ctx, tx.Hash())
+	return hash, tx, err
+}
 
-	privateKey, err := crypto.HexToECDSA(senderPrivate)
+// SendSync sends amount of the native coin and waits for the receipt. The float amount is
+// converted through its shortest decimal form rounded to the coin decimals, use SendSyncBig for
+// exact amounts.
+func (j *Jk) SendSync(ctx context.Context, senderPrivate string, receive string, amount float64) (hash string, err error) {
+	signer, err := NewHexSigner(senderPrivate)
 	if err != nil {
-		log.Log.Error(fmt.Sprintf("recover key err: %v", err))
-		return "", PrivateKeyError
-	}
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		log.Log.Error("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
-		return "", PrivateKeyError
+		return "", err
 	}
 
-	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
-	log.Log.Debug("Public Key: ", hexutil.Encode(publicKeyBytes))
+	coins, err := j.ParseCoinAmount(decimalFromFloat(amount, j.net.Decimals))
+	if err != nil {
+		return "", err
+	}
+	return j.SendSyncBig(ctx, signer, receive, coins)
+}
 
-	address := crypto.PubkeyToAddress(*publicKeyECDSA).Hex()
-	log.Log.Debug("Address: ", address)
+// SendSyncBig sends amount base units of the native coin and waits for the receipt.
+func (j *Jk) SendSyncBig(ctx context.Context, signer Signer, receive string, amount *big.Int) (hash string, err error) {
+	return j.sendCoin(ctx, signer, receive, amount, nil, true)
+}
 
-	balanceContract, decimals, err := j.GetBalanceOfContract(ctx, address, contractAddr)
+func (j *Jk) SendAsync(ctx context.Context, senderPrivate string, receive string, amount float64, nonce uint64) (hash string, err error) {
+	signer, err := NewHexSigner(senderPrivate)
 	if err != nil {
 		return "", err
 	}
 
-	if balanceContract < amount {
-		return "", BalanceLessAmountError
+	coins, err := j.ParseCoinAmount(decimalFromFloat(amount, j.net.Decimals))
+	if err != nil {
+		return "", err
 	}
+	return j.SendAsyncBig(ctx, signer, receive, coins, nonce)
+}
 
-	from := common.HexToAddress(address)
-	to := common.HexToAddress(receive)
-	contract := common.HexToAddress(contractAddr)
+// SendAsyncBig broadcasts amount base units of the native coin, nonce 0 lets the NonceManager pick one.
+func (j *Jk) SendAsyncBig(ctx context.Context, signer Signer, receive string, amount *big.Int, nonce uint64) (hash string, err error) {
+	return j.sendCoin(ctx, signer, receive, amount, managedNonce(nonce), false)
+}
 
-	strAmount := fmt.Sprintf("%f", amount)
-	f, success := new(big.Float).SetString(strAmount)
-	if !success {
+func (j *Jk) sendCoin(ctx context.Context, signer Signer, receive string, coins *big.Int, nonce *uint64, wait bool) (hash string, err error) {
+	if !common.IsHexAddress(receive) {
+		return "", NotAnHexAddress.With("address", receive)
+	}
+	if coins == nil || coins.Sign() < 0 {
 		return "", AmountError
 	}
 
-	fCoins := new(big.Float).Mul(f, big.NewFloat(decimals))
-	coins, _ := fCoins.Int(nil)
-
-	log.Log.Debug("from: ", from, " to: ", to, " contractAddr: ", contractAddr, " coins: ", coins)
-
-	gasPrice, err := client.SuggestGasPrice(ctx)
-	log.Log.Debug("gasPrice: ", gasPrice)
-
-	balance, err := client.BalanceAt(ctx, from, nil)
-	log.Log.Debug("balance: ", balance)
+	log.Log.Debug("from: ", signer.Address(), "to: ", receive, "coins: ", coins)
+	return j.sendInput(ctx, signer, txRequest{to: common.HexToAddress(receive), value: coins, gasLimit: 30000, nonce: nonce}, wait)
+}
 
-	pendingNonceNew, err := client.PendingNonceAt(ctx, from)
+func (j *Jk) SendContractSync(ctx context.Context, senderPrivate string, receive string, amount float64, contractAddr string) (hash string, err error) {
+	signer, err := NewHexSigner(senderPrivate)
 	if err != nil {
 		return "", err
 	}
 
-	log.Log.Debug("pendingNonce: ", pendingNonce, " pendingNonceNew: ", pendingNonceNew)
-	if pendingNonce < pendingNonceNew {
-		return "", NonceToSmall
-	}
-
-	erc20Abi, err := abi.JSON(strings.NewReader(AbiErc20))
+	coins, err := j.parseTokenFloat(ctx, contractAddr, amount)
 	if err != nil {
-		return
+		return "", err
 	}
+	return j.SendContractSyncBig(ctx, signer, receive, coins, contractAddr)
+}
 
-	input, err := erc20Abi.Pack("transfer", to, coins)
-	if err != nil {
-		return
-	}
+// SendContractSyncBig transfers amount token base units and waits for the receipt.
+func (j *Jk) SendContractSyncBig(ctx context.Context, signer Signer, receive string, amount *big.Int, contractAddr string) (hash string, err error) {
+	return j.sendToken(ctx, signer, receive, amount, contractAddr, nil, false, true)
+}
 
-	msgGas := ethereum.CallMsg{
-		From: from,
-		To:   &contract,
-		Data: input,
+func (j *Jk) SendContractSyncWithNonce(ctx context.Context, senderPrivate string, receive string, amount float64, contractAddr string, pendingNonce uint64) (hash string, err error) {
+	signer, err := NewHexSigner(senderPrivate)
+	if err != nil {
+		return "", err
 	}
 
-	gasLimit, err := client.EstimateGas(ctx, msgGas)
-	log.Log.Debug("gasLimit: ", gasLimit)
-
+	coins, err := j.parseTokenFloat(ctx, contractAddr, amount)
 	if err != nil {
 		return "", err
 	}
+	return j.SendContractSyncWithNonceBig(ctx, signer, receive, coins, contractAddr, pendingNonce)
+}
 
-	gas := new(big.Int).Mul(gasPrice, big.NewInt(int64(gasLimit)))
-	log.Log.Debug("gas: ", gas)
+// SendContractSyncWithNonceBig uses pendingNonce as given, 0 included, it must not be below the pending nonce.
+func (j *Jk) SendContractSyncWithNonceBig(ctx context.Context, signer Signer, receive string, amount *big.Int, contractAddr string, pendingNonce uint64) (hash string, err error) {
+	return j.sendToken(ctx, signer, receive, amount, contractAddr, &pendingNonce, true, true)
+}
 
-	if balance.Cmp(gas) <= 0 {
-		return "", BalanceLessGasError
+func (j *Jk) SendContractAsync(ctx context.Context, senderPrivate string, receive string, amount float64, nonce uint64, contractAddr string) (hash string, err error) {
+	signer, err := NewHexSigner(senderPrivate)
+	if err != nil {
+		return "", err
 	}
 
-	unsignedTx := types.NewTransaction(pendingNonce, contract, big.NewInt(0), gasLimit, gasPrice, input)
-	signedTx, _ := types.SignTx(unsignedTx, types.NewEIP155Signer(big.NewInt(MainNetChainId)), privateKey)
-
-	err = client.SendTransaction(ctx, signedTx)
+	coins, err := j.parseTokenFloat(ctx, contractAddr, amount)
 	if err != nil {
-		log.Log.Error("send transaction", err)
 		return "", err
 	}
+	return j.SendContractAsyncBig(ctx, signer, receive, coins, nonce, contractAddr)
+}
 
-	txHash := signedTx.Hash()
-	log.Log.Debug("sendTx txHash:", txHash)
-
-	hash = txHash.Hex()
-	retryTimes := 0
-	for {
-		select {
-		case <-time.NewTimer(MaxRetryTimeDurationSeconds * time.Second).C:
-			if retryTimes >= MaxRetrySync {
-				return hash, nil
-			}
-
-			receipt, err := client.TransactionReceipt(ctx, txHash)
-			if err != nil {
-				log.Log.Error("get transaction receipt: ", err)
-				retryTimes++
-				continue
-			}
-
-			if receipt.Status == types.ReceiptStatusSuccessful && receipt.BlockNumber != nil {
-				log.Log.Debug("get transaction success status", " Tx In BlockNumber: ", receipt.BlockNumber, " GasUse: ", receipt.GasUsed, " Logs: ", receipt.Logs)
-				return hash, nil
-			}
-
-			if receipt.Status == types.ReceiptStatusFailed {
-				log.Log.Error("get transaction failed status")
-				return "", SendTransactionFailedError
-			}
-
-		case <-ctx.Done():
-			log.Log.Error("get transaction time out context")
-			return "", ReadTransactionTimeOutError
-		}
-	}
+// SendContractAsyncBig broadcasts a transfer of amount token base units, nonce 0 lets the NonceManager pick one.
+func (j *Jk) SendContractAsyncBig(ctx context.Context, signer Signer, receive string, amount *big.Int, nonce uint64, contractAddr string) (hash string, err error) {
+	return j.sendToken(ctx, signer, receive, amount, contractAddr, managedNonce(nonce), false, false)
 }
 
-func (j *Jk) SendContractAsync(ctx context.Context, senderPrivate string, receive string, amount float64, nonce uint64, contractAddr string) (hash string, err error) {
+// sendToken nonce 为空时由 NonceManager 分配, strictNonce 为 true 时指定的 nonce 必须不小于链上的 pending nonce
+func (j *Jk) sendToken(ctx context.Context, signer Signer, receive string, coins *big.Int, contractAddr string, nonce *uint64, strictNonce bool, wait bool) (hash string, err error) {
 	if !common.IsHexAddress(receive) {
-		return "", NotAnHexAddress
+		return "", NotAnHexAddress.With("address", receive)
 	}
 
 	if contractAddr == "" {
 		return "", ContractNotEmpty
 	}
 
-	client, err := j.Acquire()
-	if err != nil {
-		return
-	}
-	defer j.Release(client)
-
-	if strings.HasPrefix(senderPrivate, "0x") {
-		senderPrivate = senderPrivate[2:]
-	}
-	privateKey, err := crypto.HexToECDSA(senderPrivate)
-	if err != nil {
-		log.Log.Error(fmt.Sprintf("recover key err: %v", err))
-		return "", PrivateKeyError
-	}
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		log.Log.Error("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
-		return "", PrivateKeyError
+	if coins == nil || coins.Sign() < 0 {
+		return "", AmountError
 	}
 
-	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
-	log.Log.Debug("Public Key: ", hexutil.Encode(publicKeyBytes))
-
-	address := crypto.PubkeyToAddress(*publicKeyECDSA).Hex()
-	log.Log.Debug("Address: ", address)
+	from := signer.Address()
 
-	balanceContract, decimals, err := j.GetBalanceOfContract(ctx, address, contractAddr)
+	balanceContract, _, err := j.GetBalanceOfContractBig(ctx, from.Hex(), contractAddr)
 	if err != nil {
 		return "", err
 	}
 
-	if balanceContract < amount {
-		return "", BalanceLessAmountError
+	if balanceContract.Cmp(coins) < 0 {
+		return "", BalanceLessAmountError.With("address", from.Hex()).With("token", contractAddr).With("need", coins).With("available", balanceContract)
 	}
 
-	from := common.HexToAddress(address)
 	to := common.HexToAddress(receive)
-	contract := common.HexToAddress(contractAddr)
-
-	strAmount := fmt.Sprintf("%f", amount)
-	f, success := new(big.Float).SetString(strAmount)
-	if !success {
-		return "", AmountError
-	}
-
-	fCoins := new(big.Float).Mul(f, big.NewFloat(decimals))
-	coins, _ := fCoins.Int(nil)
 
 	log.Log.Debug("from: ", from, " to: ", to, " contractAddr: ", contractAddr, " coins: ", coins)
 
-	gasPrice, err := client.SuggestGasPrice(ctx)
-	if err != nil {
-		log.Log.Error("get balance err: ", err)
-		return "", err
-	}
-	log.Log.Debug("gasPrice: ", gasPrice)
-
-	balance, err := client.BalanceAt(ctx, from, nil)
-	if err != nil {
-		log.Log.Error("get balance err: ", err)
-		return "", err
-	}
-	log.Log.Debug("balance: ", balance)
-
-	var pendingNonce uint64
-	if nonce == 0 {
-		pendingNonce, err = client.PendingNonceAt(ctx, from)
+	if nonce != nil && strictNonce {
+		var pendingNonceNew uint64
+		err = j.withClient(ctx, func(client *ethclient.Client) (err error) {
+			pendingNonceNew, err = client.PendingNonceAt(ctx, from)
+			return
+		})
 		if err != nil {
 			log.Log.Error("get pendingNonce err: ", err)
 			return "", err
 		}
-	} else {
-		pendingNonce = nonce
-	}
-	log.Log.Debug("pendingNonce: ", pendingNonce)
 
-	erc20Abi, err := abi.JSON(strings.NewReader(AbiErc20))
-	if err != nil {
-		return
+		log.Log.Debug("pendingNonce: ", *nonce, " pendingNonceNew: ", pendingNonceNew)
+		if *nonce < pendingNonceNew {
+			return "", NonceToSmall.With("address", from.Hex()).With("nonce", *nonce).With("pending", pendingNonceNew)
+		}
 	}
 
-	input, err := erc20Abi.Pack("transfer", to, coins)
+	input, err := j.tokens.ABI().Pack("transfer", to, coins)
 	if err != nil {
 		return
 	}
+	return j.sendInput(ctx, signer, txRequest{to: common.HexToAddress(contractAddr), value: big.NewInt(0), input: input, nonce: nonce}, wait)
+}
 
-	msgGas := ethereum.CallMsg{
-		From: from,
-		To:   &contract,
-		Data: input,
-	}
-
-	gasLimit, err := client.EstimateGas(ctx, msgGas)
-	log.Log.Debug("gasLimit: ", gasLimit)
-
-	if err != nil {
-		return "", err
-	}
-
-	gas := new(big.Int).Mul(gasPrice, big.NewInt(int64(gasLimit)))
-	log.Log.Debug("gas: ", gas)
-
-	if balance.Cmp(gas) <= 0 {
-		return "", BalanceLessGasError
-	}
-
-	unsignedTx := types.NewTransaction(pendingNonce, contract, big.NewInt(0), gasLimit, gasPrice, input)
-	signedTx, _ := types.SignTx(unsignedTx, types.NewEIP155Signer(big.NewInt(MainNetChainId)), privateKey)
-
-	err = client.SendTransaction(ctx, signedTx)
+func (j *Jk) SendContractInputDataSync(ctx context.Context, senderPrivate string, inputData []byte, contractAddr string) (hash string, err error) {
+	signer, err := NewHexSigner(senderPrivate)
 	if err != nil {
-		log.Log.Error("send transaction", err)
 		return "", err
 	}
+	return j.SendContractInputDataSyncWithSigner(ctx, signer, inputData, contractAddr)
+}
 
-	txHash := signedTx.Hash()
-	log.Log.Debug("sendTx txHash:", txHash)
+// SendContractInputDataSyncWithSigner calls contractAddr with packed inputData and waits for the receipt.
+func (j *Jk) SendContractInputDataSyncWithSigner(ctx context.Context, signer Signer, inputData []byte, contractAddr string) (hash string, err error) {
+	return j.sendInput(ctx, signer, txRequest{to: common.HexToAddress(contractAddr), value: big.NewInt(0), input: inputData}, true)
+}
 
-	hash = txHash.Hex()
-	return
+// txRequest 一笔要签名发送的交易
+type txRequest struct {
+	to    common.Address
+	value *big.Int
+	input []byte
+	//gasLimit 为 0 时估算
+	gasLimit uint64
+	//nonce 为空时由 NonceManager 分配
+	nonce *uint64
+	//errorABIs 用来解析回滚原因
+	errorABIs []abi.ABI
 }
 
-func (j *Jk) SendContractInputDataSync(ctx context.Context, senderPrivate string, inputData []byte, contractAddr string) (hash string, err error) {
+// sendInput 检查交易费和余额, 分配 nonce, 签名广播, wait 时等回执
+func (j *Jk) sendInput(ctx context.Context, signer Signer, req txRequest, wait bool) (hash string, err error) {
 	client, err := j.Acquire()
 
 	if err != nil {
 		return
 	}
-	defer j.Release(client)
-
-	if strings.HasPrefix(senderPrivate, "0x") {
-		senderPrivate = senderPrivate[2:]
-	}
-	privateKey, err := crypto.HexToECDSA(senderPrivate)
-	if err != nil {
-		log.Log.Error(fmt.Sprintf("recover key err: %v", err))
-		return "", PrivateKeyError
-	}
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		log.Log.Error("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
-		return "", PrivateKeyError
-	}
-
-	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
-	log.Log.Debug("Public Key: ", hexutil.Encode(publicKeyBytes))
+	defer func() { j.releaseWithError(client, err) }()
 
-	address := crypto.PubkeyToAddress(*publicKeyECDSA).Hex()
-	log.Log.Debug("Address: ", address)
-
-	from := common.HexToAddress(address)
+	from := signer.Address()
 
 	balance, err := client.BalanceAt(ctx, from, nil)
 	if err != nil {
@@ -1063,49 +487,60 @@ func (j *Jk) SendContractInputDataSync(ctx context.Context, senderPrivate string
 	}
 	log.Log.Debug("balance: ", balance)
 
-	gasPrice, err := client.SuggestGasPrice(ctx)
+	fee, err := j.suggestFee(ctx, client)
 	if err != nil {
-		log.Log.Error("get balance err: ", err)
 		return "", err
 	}
-	log.Log.Debug("gasPrice: ", gasPrice)
 
-	to := common.HexToAddress(contractAddr)
-	msg := ethereum.CallMsg{
-		From: from,
-		To:   &to,
-		Data: inputData,
-	}
+	gasLimit := req.gasLimit
+	if gasLimit == 0 {
+		msg := ethereum.CallMsg{
+			From:  from,
+			To:    &req.to,
+			Value: req.value,
+			Data:  req.input,
+		}
 
-	gasLimit, err := client.EstimateGas(ctx, msg)
-	if err != nil {
-		log.Log.Error("EstimateGas: ", err)
-		return "", err
+		gasLimit, err = client.EstimateGas(ctx, msg)
+		if err != nil {
+			log.Log.Error("EstimateGas: ", err)
+			return "", j.asRevert(err, req.errorABIs...)
+		}
 	}
 
 	//100000000000 * 10000000
 	log.Log.Debug("gasLimit: ", gasLimit)
 
-	gas := new(big.Int).Mul(gasPrice, big.NewInt(int64(gasLimit)))
+	gas := fee.maxCost(gasLimit)
 	log.Log.Debug("gas: ", gas)
 
 	if balance.Cmp(gas) <= 0 {
-		return "", errors.New(fmt.Sprintf("gas not enough, gas: %v balance: %v", gas.String(), balance.String()))
+		return "", BalanceLessGasError.With("address", from.Hex()).With("need", gas).With("available", balance)
+	}
+	if need := new(big.Int).Add(gas, req.value); req.value.Sign() > 0 && balance.Cmp(need) <= 0 {
+		return "", BalanceLessGasAddAmountError.With("address", from.Hex()).With("need", need).With("available", balance)
 	}
 
-	pendingNonce, err := client.PendingNonceAt(ctx, from)
+	chainId, err := j.ChainId(ctx)
 	if err != nil {
 		return "", err
 	}
 
+	pendingNonce, done, err := j.takeNonce(ctx, from, req.nonce)
+	if err != nil {
+		return "", err
+	}
 	log.Log.Debug("pendingNonce: ", pendingNonce)
-	unsignedTx := types.NewTransaction(pendingNonce, to, big.NewInt(0), gasLimit, gasPrice, inputData)
-	signedTx, err := types.SignTx(unsignedTx, types.NewEIP155Signer(big.NewInt(MainNetChainId)), privateKey)
+
+	unsignedTx := fee.newTx(chainId, pendingNonce, req.to, req.value, gasLimit, req.input)
+	signedTx, err := signer.SignTx(unsignedTx, chainId)
 	if err != nil {
+		done(err)
 		return "", err
 	}
 
-	err = client.SendTransaction(ctx, signedTx)
+	err = j.sendTransaction(ctx, client, signedTx)
+	done(err)
 	if err != nil {
 		log.Log.Error("send transaction", err)
 		return "", err
@@ -1114,169 +549,76 @@ func (j *Jk) SendContractInputDataSync(ctx context.Context, senderPrivate string
 	txHash := signedTx.Hash()
 	log.Log.Debug("sendTx txHash:", txHash)
 
-	hash = txHash.Hex()
-	retryTimes := 0
-	for {
-		select {
-		case <-time.NewTimer(MaxRetryTimeDurationSeconds * time.Second).C:
-			if retryTimes >= MaxRetrySync {
-				return hash, nil
-			}
-
-			receipt, err := client.TransactionReceipt(ctx, txHash)
-			if err != nil {
-				log.Log.Error("get transaction receipt: ", err)
-				retryTimes++
-				continue
-			}
-
-			if receipt.Status == types.ReceiptStatusSuccessful && receipt.BlockNumber != nil {
-				log.Log.Debug("get transaction success status", " Tx In BlockNumber: ", receipt.BlockNumber, " GasUse: ", receipt.GasUsed, " Logs: ", receipt.Logs)
-				return hash, nil
-			}
-
-			if receipt.Status == types.ReceiptStatusFailed {
-				log.Log.Error("get transaction failed status")
-				return "", SendTransactionFailedError
-			}
-
-		case <-ctx.Done():
-			log.Log.Error("get transaction time out context")
-			return "", ReadTransactionTimeOutError
-		}
+	if !wait {
+		return txHash.Hex(), nil
 	}
-}
 
-func (j *Jk) IsContract(ctx context.Context, address string) (bool, error) {
-	client, err := j.Acquire()
-	if err != nil {
-		return false, err
+	//超时或被丢弃时也返回 hash, 方便调用方继续跟踪
+	err = j.waitReceipt(ctx, txHash, req.errorABIs...)
+	if errors.Is(err, TransactionDroppedError) {
+		//丢弃的交易留下的 nonce 空洞不会被填上, 后面的交易都会卡住, 从链上重新读
+		log.Log.Warn("tx ", txHash.Hex(), " dropped, resync nonce of ", from.Hex())
+		j.nonces.Resync(from)
 	}
+	return txHash.Hex(), err
+}
 
-	defer j.Release(client)
-
-	at, err := client.CodeAt(ctx, common.HexToAddress(address), nil)
+// IsContract reports whether address has code, at the latest block unless at is given.
+func (j *Jk) IsContract(ctx context.Context, address string, at ...BlockRef) (bool, error) {
+	var code []byte
+	err := j.withClient(ctx, func(client *ethclient.Client) (err error) {
+		code, err = j.codeAt(ctx, client, common.HexToAddress(address), blockOf(at))
+		return
+	})
 	if err != nil {
 		return false, err
 	}
 
-	if string(at) != "" {
+	if string(code) != "" {
 		return true, nil
 	}
 
 	return false, nil
 }
 
-func (j *Jk) StartScan(startNumber uint64, timeInternal time.Duration, handle func(tx *types.Transaction, block *types.Block) error) error {
+// StartScan scans blocks from startNumber and hands every transaction to handle. Progress is kept
+// in store under namespace, so a restarted scanner resumes where it stopped.
+//
+// Deprecated: StartScan never returns, use NewScanner and Scanner.Start.
+func (j *Jk) StartScan(store CheckpointStore, namespace string, startNumber uint64, timeInternal time.Duration, handle func(tx *types.Transaction, block *types.Block) error, opts ...ScanOption) error {
 	if startNumber < 1 {
 		return errors.New("start number can not < 1")
 	}
 
 	defer j.Close()
-	latestNumber := uint64(0)
-
-	mutex := sync.Mutex{}
-	for {
-		select {
-		case <-time.NewTimer(timeInternal).C:
-			err := j.ExecuteBlocks(&mutex, latestNumber, startNumber, handle)
-			if err != nil {
-				log.Log.Error("ExecuteBlocks err", err, "continues")
-				continue
-			}
-		}
-	}
-}
-
-func (j *Jk) ExecuteBlocks(mutex *sync.Mutex, startNumber, latestNumber uint64, handle func(tx *types.Transaction, block *types.Block) error) error {
-
-	mutex.Lock()
-	defer func() {
-		log.Log.Debug("defer unlock")
-		mutex.Unlock()
-	}()
-
-	client, err := j.Acquire()
-	defer func() {
-		log.Log.Debug("defer Release")
-		j.Release(client)
-	}()
-
-	if err != nil {
-		log.Log.Error("acquire client: ", err)
-		return err
-	}
 
-	highestNumber, err := client.BlockNumber(context.Background())
+	s, err := j.NewScanner(store, namespace, startNumber, handle, append([]ScanOption{WithPollInterval(timeInternal)}, opts...)...)
 	if err != nil {
-		log.Log.Error("get latest block number: ", err)
 		return err
 	}
+	return s.Start(context.Background())
+}
 
-	latestNumber = j.readLatestNumber(highestNumber)
-	if startNumber > latestNumber {
-		latestNumber = startNumber
-	}
-
-	log.Log.Debug("startNumber: ", startNumber, " highestNumber: ", highestNumber, " latestNumber: ", latestNumber)
+// ExecuteBlocks handles one batch of confirmed blocks after the checkpoint.
+//
+// Deprecated: use Scanner.RunOnce.
+func (j *Jk) ExecuteBlocks(mutex *sync.Mutex, store CheckpointStore, namespace string, startNumber uint64, handle func(tx *types.Transaction, block *types.Block) error, opts ...ScanOption) error {
+	mutex.Lock()
+	defer mutex.Unlock()
 
-	diffHeight := highestNumber - latestNumber
-	if diffHeight <= 0 {
-		log.Log.Debug("diffHeight <= 0: ", diffHeight, " it may no new block or block rollback, stop execute")
+	s, err := j.NewScanner(store, namespace, startNumber, handle, opts...)
+	if err != nil {
 		return err
 	}
-
-	log.Log.Debug("diffHeight: ", diffHeight)
-
-	for height := latestNumber + 1; height < latestNumber+diffHeight; height++ {
-		time.Sleep(1 * time.Second)
-
-		var block *types.Block
-		block, err = client.BlockByNumber(context.Background(), big.NewInt(int64(height)))
-
-		log.Log.Debug("==============handle start height: ", height, "================================")
-
-		if err != nil {
-			log.Log.Error("get block height: ", height, " happened, it may lost height, stop now. ", " err info: ", err)
-			return err
-		}
-
-		transactions := block.Transactions()
-		for _, tx := range transactions {
-			log.Log.Debug("notify handle tx for business", " txHash: ", tx.Hash(), " blockNumber: ", block.Number())
-
-			err = handle(tx, block)
-
-			if err != nil {
-				log.Log.Error("handle tx: ", tx.Hash(), " err happened ", "record block and to next")
-
-				err = j.writeErrorTx(tx, block)
-				if err != nil {
-					log.Log.Error("write tx err info: ", tx, " err happened ", "record block and to next")
-				}
-			}
-		}
-
-		err = j.writeLatestNumber(height)
-		if err != nil {
-			log.Log.Error("write latest number: ", err)
-			return err
-		}
-
-		log.Log.Debug("==============handle end height: ", height, "================================")
-	}
-
-	return nil
+	return s.RunOnce(context.Background())
 }
 
 func (j *Jk) GetTransactionReceiptByHash(ctx context.Context, hash string) (*types.Receipt, error) {
-	client, err := j.Acquire()
-	if err != nil {
-		return nil, err
-	}
-	defer j.Release(client)
-
-	tx, err := client.TransactionReceipt(ctx, common.HexToHash(hash))
+	var tx *types.Receipt
+	err := j.withClient(ctx, func(client *ethclient.Client) (err error) {
+		tx, err = client.TransactionReceipt(ctx, common.HexToHash(hash))
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -1285,13 +627,12 @@ func (j *Jk) GetTransactionReceiptByHash(ctx context.Context, hash string) (*typ
 }
 
 func (j *Jk) GetTransactionByHash(ctx context.Context, hash string) (*types.Transaction, bool, error) {
-	client, err := j.Acquire()
-	if err != nil {
-		return nil, false, err
-	}
-	defer j.Release(client)
-
-	tx, pending, err := client.TransactionByHash(ctx, common.HexToHash(hash))
+	var tx *types.Transaction
+	var pending bool
+	err := j.withClient(ctx, func(client *ethclient.Client) (err error) {
+		tx, pending, err = client.TransactionByHash(ctx, common.HexToHash(hash))
+		return
+	})
 	if err != nil {
 		return nil, pending, err
 	}
@@ -1300,13 +641,11 @@ func (j *Jk) GetTransactionByHash(ctx context.Context, hash string) (*types.Tran
 }
 
 func (j *Jk) GetBlockByHash(ctx context.Context, hash string) (*types.Block, error) {
-	client, err := j.Acquire()
-	if err != nil {
-		return nil, err
-	}
-	defer j.Release(client)
-
-	block, err := client.BlockByHash(ctx, common.HexToHash(hash))
+	var block *types.Block
+	err := j.withClient(ctx, func(client *ethclient.Client) (err error) {
+		block, err = client.BlockByHash(ctx, common.HexToHash(hash))
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -1337,7 +676,7 @@ func (j *Jk) writeErrorTx(tx *types.Transaction, block *types.Block) error {
 
 	defer open.Close()
 
-	msg, err := tx.AsMessage(types.NewEIP155Signer(tx.ChainId()), block.BaseFee())
+	msg, err := tx.AsMessage(types.LatestSignerForChainID(tx.ChainId()), block.BaseFee())
 	if err != nil {
 		log.Log.Error("decode to message: ", err)
 		return err
@@ -1361,77 +700,3 @@ func (j *Jk) writeErrorTx(tx *types.Transaction, block *types.Block) error {
 
 	return nil
 }
-
-func (j *Jk) writeLatestNumber(latestNumber uint64) error {
-
-	j.rw.Lock()
-	defer j.rw.Unlock()
-
-	var open *os.File
-	path := "./latestNumber.info"
-	_, err := os.Stat(path)
-	if os.IsNotExist(err) {
-		open, err = os.Create(path)
-		if err != nil {
-			log.Log.Error("create latestNumber.info: ", err)
-		}
-	} else {
-		open, err = os.OpenFile(path, os.O_RDWR, os.ModeAppend)
-		if err != nil {
-			log.Log.Error("open latestNumber.info: ", err)
-		}
-	}
-
-	defer open.Close()
-
-	formatUint := strconv.FormatUint(latestNumber, 10)
-	_, err = open.Write([]byte(formatUint))
-	if err != nil {
-		log.Log.Error("write latestNumber.info: ", err)
-		return err
-	}
-
-	return nil
-}
-
-func (j *Jk) readLatestNumber(highestNumber uint64) uint64 {
-	j.rw.Lock()
-	defer j.rw.Unlock()
-
-	var open *os.File
-	path := "./latestNumber.info"
-	_, err := os.Stat(path)
-	if os.IsNotExist(err) {
-		open, err = os.Create(path)
-		if err != nil {
-			log.Log.Error("create latestNumber.info: ", err)
-		}
-	} else {
-		open, err = os.OpenFile(path, os.O_RDWR, os.ModeAppend)
-		if err != nil {
-			log.Log.Error("open latestNumber.info: ", err)
-		}
-	}
-
-	defer open.Close()
-
-	all, err := ioutil.ReadAll(open)
-	if err != nil {
-		log.Log.Error("read latestNumber.info: ", err)
-		return highestNumber
-	}
-
-	a := strings.TrimSpace(string(all))
-	if a == "" {
-		return 0
-	}
-
-	f, success := new(big.Float).SetString(a)
-	if !success {
-		log.Log.Error("float set string latestNumber.info: ", err)
-		return highestNumber
-	}
-
-	u, _ := f.Uint64()
-	return u
-}
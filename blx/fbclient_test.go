@@ -785,7 +785,7 @@ func TestJk_SendSyncBalanceNotEnough(t *testing.T) {
 	jk := NewJk(2, MainNet, logrus.DebugLevel)
 	of, err := jk.SendSync(context.Background(), senderPrivate, to, 50)
 	if err != nil {
-		if err == BalanceLessGasAddAmountError {
+		if errors.Is(err, BalanceLessGasAddAmountError) {
 
 		} else {
 			t.Error(err)
@@ -797,7 +797,7 @@ func TestJk_SendSyncBalanceNotEnough(t *testing.T) {
 
 func TestStartScan(t *testing.T) {
 	jk := NewJk(2, MainNet, logrus.DebugLevel)
-	err := jk.StartScan(1, 2*time.Second, func(tx *types.Transaction, block *types.Block) error {
+	err := jk.StartScan(NewMemoryCheckpointStore(), "test", 1, 2*time.Second, func(tx *types.Transaction, block *types.Block) error {
 
 		msg, err := tx.AsMessage(types.NewEIP155Signer(tx.ChainId()), block.BaseFee())
 		if err != nil {
@@ -825,7 +825,7 @@ func TestStartScan(t *testing.T) {
 
 func TestStartScanError(t *testing.T) {
 	jk := NewJk(2, MainNet, logrus.DebugLevel)
-	err := jk.StartScan(330600, 2*time.Second, func(tx *types.Transaction, block *types.Block) error {
+	err := jk.StartScan(NewMemoryCheckpointStore(), "test-error", 330600, 2*time.Second, func(tx *types.Transaction, block *types.Block) error {
 
 		msg, err := tx.AsMessage(types.NewEIP155Signer(tx.ChainId()), block.BaseFee())
 		if err != nil {
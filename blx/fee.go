@@ -0,0 +1,102 @@
+package blx
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// txFee 交易费参数, gasFeeCap 为空时是 legacy 交易
+type txFee struct {
+	gasPrice  *big.Int
+	gasTipCap *big.Int
+	gasFeeCap *big.Int
+}
+
+func (f *txFee) dynamic() bool {
+	return f.gasFeeCap != nil
+}
+
+// maxPrice 每单位 gas 最多付出的价格, 用来检查余额
+func (f *txFee) maxPrice() *big.Int {
+	if f.dynamic() {
+		return f.gasFeeCap
+	}
+	return f.gasPrice
+}
+
+// maxCost 交易费上限
+func (f *txFee) maxCost(gasLimit uint64) *big.Int {
+	return new(big.Int).Mul(f.maxPrice(), new(big.Int).SetUint64(gasLimit))
+}
+
+func (f *txFee) newTx(chainId *big.Int, nonce uint64, to common.Address, value *big.Int, gasLimit uint64, data []byte) *types.Transaction {
+	if !f.dynamic() {
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: f.gasPrice,
+			Gas:      gasLimit,
+			To:       &to,
+			Value:    value,
+			Data:     data,
+		})
+	}
+
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainId,
+		Nonce:     nonce,
+		GasTipCap: f.gasTipCap,
+		GasFeeCap: f.gasFeeCap,
+		Gas:       gasLimit,
+		To:        &to,
+		Value:     value,
+		Data:      data,
+	})
+}
+
+// suggestFee builds EIP-1559 fees from the latest base fee and the suggested tip,
+// falling back to a legacy gas price when the chain reports no base fee.
+func (j *Jk) suggestFee(ctx context.Context, client *ethclient.Client) (*txFee, error) {
+	if !j.opts.legacyTx {
+		header, err := client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			log.Log.Error("get latest header err: ", err)
+			return nil, err
+		}
+
+		if header.BaseFee != nil {
+			tip, err := client.SuggestGasTipCap(ctx)
+			if err != nil {
+				log.Log.Error("get gas tip cap err: ", err)
+				return nil, err
+			}
+
+			tip = mulFloat(tip, j.opts.tipMultiplier)
+			feeCap := new(big.Int).Add(mulFloat(header.BaseFee, j.opts.baseFeeMultiplier), tip)
+
+			log.Log.Debug("baseFee: ", header.BaseFee, " gasTipCap: ", tip, " gasFeeCap: ", feeCap)
+			return &txFee{gasTipCap: tip, gasFeeCap: feeCap}, nil
+		}
+	}
+
+	gasPrice, err := client.SuggestGasPrice(ctx)
+	if err != nil {
+		log.Log.Error("get gas price err: ", err)
+		return nil, err
+	}
+	log.Log.Debug("gasPrice: ", gasPrice)
+
+	return &txFee{gasPrice: gasPrice}, nil
+}
+
+func mulFloat(v *big.Int, m float64) *big.Int {
+	if m <= 0 || m == 1 {
+		return new(big.Int).Set(v)
+	}
+	r, _ := new(big.Float).Mul(new(big.Float).SetInt(v), big.NewFloat(m)).Int(nil)
+	return r
+}
@@ -0,0 +1,60 @@
+package blx
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSuggestFee(t *testing.T) {
+	node := newTestNode(t)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithFeeMultipliers(2, 1.5), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	client, err := jk.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Release(client)
+
+	// 没有 baseFee 的链使用 legacy 交易
+	fee, err := jk.suggestFee(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee.dynamic() || fee.gasPrice.Int64() != 1e9 {
+		t.Fatal("expect legacy fee, got ", fee)
+	}
+
+	node.eth.mu.Lock()
+	node.eth.baseFee = big.NewInt(10e9)
+	node.eth.mu.Unlock()
+
+	fee, err = jk.suggestFee(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fee.dynamic() {
+		t.Fatal("expect dynamic fee")
+	}
+	if fee.gasTipCap.Int64() != 3e9 || fee.gasFeeCap.Int64() != 23e9 {
+		t.Fatal("unexpected fee caps ", fee.gasTipCap, fee.gasFeeCap)
+	}
+	if fee.maxCost(21000).Cmp(big.NewInt(23e9*21000)) != 0 {
+		t.Fatal("unexpected max cost ", fee.maxCost(21000))
+	}
+
+	to := common.HexToAddress("0x6cAa27dFc890d772B5fA3dB3dAaa39Bf576DC109")
+	tx := fee.newTx(big.NewInt(1337), 1, to, big.NewInt(1), 21000, nil)
+	if tx.Type() != types.DynamicFeeTxType {
+		t.Fatal("expect dynamic fee tx, got type ", tx.Type())
+	}
+}
@@ -0,0 +1,269 @@
+package blx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// maxBatchItems 一个 batch 里最多的请求数, 一个区块的交易很多时回执要分几次取
+const maxBatchItems = 200
+
+// blockChunk 一次批量请求取回的连续区块, 开启回执时 receipts[i] 对应 blocks[i] 的交易.
+// hashes[i] 是节点返回的区块哈希, 非 geth 格式的链上和本地算出的 Hash() 不同
+type blockChunk struct {
+	blocks   []*types.Block
+	hashes   []common.Hash
+	receipts [][]*types.Receipt
+	err      error
+}
+
+// fetchBlocks fetches from..to with concurrency workers, each asking fetchBatch blocks per
+// round trip. The chunks come out in height order whatever order they were fetched in.
+func (s *Scanner) fetchBlocks(ctx context.Context, client *ethclient.Client, from, to uint64) <-chan blockChunk {
+	batch := s.opts.fetchBatch
+	rc := s.jk.rpcOf(client)
+
+	var chunks []chan blockChunk
+	jobs := make(chan int)
+	for start := from; start <= to; start += batch {
+		chunks = append(chunks, make(chan blockChunk, 1))
+	}
+
+	workers := s.opts.concurrency
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				start := from + uint64(i)*batch
+				end := start + batch - 1
+				if end > to {
+					end = to
+				}
+
+				var c blockChunk
+				if rc != nil {
+					c.blocks, c.hashes, c.err = batchBlocks(ctx, rc, start, end)
+				} else {
+					c.blocks, c.hashes, c.err = serialBlocks(ctx, client, start, end)
+				}
+				if c.err == nil && s.opts.receipts {
+					if rc != nil {
+						c.receipts, c.err = batchReceipts(ctx, rc, c.blocks)
+					} else {
+						c.receipts, c.err = serialReceipts(ctx, client, c.blocks)
+					}
+				}
+				chunks[i] <- c
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range chunks {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	out := make(chan blockChunk)
+	go func() {
+		defer close(out)
+		for _, c := range chunks {
+			select {
+			case chunk := <-c:
+				select {
+				case out <- chunk:
+				case <-ctx.Done():
+					return
+				}
+				if chunk.err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// batchBlocks 用一个 JSON-RPC batch 取 from..to 的完整区块
+func batchBlocks(ctx context.Context, rc *rpc.Client, from, to uint64) ([]*types.Block, []common.Hash, error) {
+	raws := make([]json.RawMessage, to-from+1)
+	elems := make([]rpc.BatchElem, len(raws))
+	for i := range elems {
+		elems[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(from + uint64(i)), true},
+			Result: &raws[i],
+		}
+	}
+
+	err := rc.BatchCallContext(ctx, elems)
+	if err != nil {
+		log.Log.Error("batch get block ", from, " - ", to, " err: ", err)
+		return nil, nil, err
+	}
+
+	blocks := make([]*types.Block, len(raws))
+	hashes := make([]common.Hash, len(raws))
+	for i, elem := range elems {
+		if elem.Error != nil {
+			log.Log.Error("get block height: ", from+uint64(i), " err: ", elem.Error)
+			return nil, nil, elem.Error
+		}
+		blocks[i], hashes[i], err = decodeBlock(raws[i])
+		if err != nil {
+			log.Log.Error("decode block height: ", from+uint64(i), " err: ", err)
+			return nil, nil, err
+		}
+	}
+	return blocks, hashes, nil
+}
+
+// serialBlocks 没有 rpc 连接时逐个取, ethclient 不返回节点的哈希, 只能用本地算的
+func serialBlocks(ctx context.Context, client *ethclient.Client, from, to uint64) ([]*types.Block, []common.Hash, error) {
+	var blocks []*types.Block
+	var hashes []common.Hash
+	for height := from; height <= to; height++ {
+		block, err := client.BlockByNumber(ctx, new(big.Int).SetUint64(height))
+		if err != nil {
+			return nil, nil, err
+		}
+		blocks = append(blocks, block)
+		hashes = append(hashes, block.Hash())
+	}
+	return blocks, hashes, nil
+}
+
+// batchReceipts 批量取 blocks 里所有交易的回执
+func batchReceipts(ctx context.Context, rc *rpc.Client, blocks []*types.Block) ([][]*types.Receipt, error) {
+	receipts := make([][]*types.Receipt, len(blocks))
+	var elems []rpc.BatchElem
+	for i, block := range blocks {
+		receipts[i] = make([]*types.Receipt, len(block.Transactions()))
+		for k, tx := range block.Transactions() {
+			elems = append(elems, rpc.BatchElem{
+				Method: "eth_getTransactionReceipt",
+				Args:   []interface{}{tx.Hash()},
+				Result: &receipts[i][k],
+			})
+		}
+	}
+
+	for start := 0; start < len(elems); start += maxBatchItems {
+		end := start + maxBatchItems
+		if end > len(elems) {
+			end = len(elems)
+		}
+		err := rc.BatchCallContext(ctx, elems[start:end])
+		if err != nil {
+			log.Log.Error("batch get receipts err: ", err)
+			return nil, err
+		}
+	}
+
+	for _, elem := range elems {
+		if elem.Error != nil {
+			log.Log.Error("get receipt ", elem.Args[0], " err: ", elem.Error)
+			return nil, elem.Error
+		}
+	}
+	for i, block := range blocks {
+		for k, tx := range block.Transactions() {
+			if receipts[i][k] == nil {
+				log.Log.Error("receipt of ", tx.Hash().Hex(), " not found")
+				return nil, ethereum.NotFound
+			}
+			if receipts[i][k].TxHash != tx.Hash() {
+				return nil, errors.New("server returned receipt of another transaction")
+			}
+		}
+	}
+	return receipts, nil
+}
+
+func serialReceipts(ctx context.Context, client *ethclient.Client, blocks []*types.Block) ([][]*types.Receipt, error) {
+	receipts := make([][]*types.Receipt, len(blocks))
+	for i, block := range blocks {
+		for _, tx := range block.Transactions() {
+			receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+			if err != nil {
+				return nil, err
+			}
+			receipts[i] = append(receipts[i], receipt)
+		}
+	}
+	return receipts, nil
+}
+
+// decodeBlock 解析 eth_getBlockByNumber(full) 的结果和节点返回的区块哈希, 检查和 ethclient 一致, 不取叔块
+func decodeBlock(raw json.RawMessage) (*types.Block, common.Hash, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, common.Hash{}, ethereum.NotFound
+	}
+
+	var head types.Header
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return nil, common.Hash{}, err
+	}
+	var body struct {
+		Hash         *common.Hash         `json:"hash"`
+		Transactions []*types.Transaction `json:"transactions"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, common.Hash{}, err
+	}
+
+	if head.TxHash == types.EmptyRootHash && len(body.Transactions) > 0 {
+		return nil, common.Hash{}, errors.New("server returned non-empty transaction list but block header indicates no transactions")
+	}
+	if head.TxHash != types.EmptyRootHash && len(body.Transactions) == 0 {
+		return nil, common.Hash{}, errors.New("server returned empty transaction list but block header indicates transactions")
+	}
+
+	block := types.NewBlockWithHeader(&head).WithBody(body.Transactions, nil)
+	hash := block.Hash()
+	if body.Hash != nil {
+		hash = *body.Hash
+	}
+	return block, hash, nil
+}
+
+// blockHashAt 节点返回的 number 高度的区块哈希
+func blockHashAt(ctx context.Context, rc *rpc.Client, client *ethclient.Client, number uint64) (common.Hash, error) {
+	if rc == nil {
+		header, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return common.Hash{}, err
+		}
+		return header.Hash(), nil
+	}
+
+	var head *struct {
+		Hash common.Hash `json:"hash"`
+	}
+	if err := rc.CallContext(ctx, &head, "eth_getBlockByNumber", hexutil.EncodeUint64(number), false); err != nil {
+		return common.Hash{}, err
+	}
+	if head == nil {
+		return common.Hash{}, ethereum.NotFound
+	}
+	return head.Hash, nil
+}
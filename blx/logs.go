@@ -0,0 +1,84 @@
+package blx
+
+import (
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// DecodedLog is a receipt log matched against a known event, Args holds indexed and data fields by name.
+type DecodedLog struct {
+	Log  *types.Log
+	Name string
+	Args map[string]interface{}
+}
+
+// logDecoder 按 topic0 和 indexed 参数个数匹配事件, ERC-20 和 ERC-721 的 Transfer 签名相同只能这样区分
+type logDecoder struct {
+	events []abi.Event
+}
+
+func newLogDecoder(abiJSONs ...string) (*logDecoder, error) {
+	d := &logDecoder{}
+	for _, s := range abiJSONs {
+		parsed, err := abi.JSON(strings.NewReader(s))
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range parsed.Events {
+			d.events = append(d.events, ev)
+		}
+	}
+	return d, nil
+}
+
+func (d *logDecoder) match(l *types.Log) *abi.Event {
+	if len(l.Topics) == 0 {
+		return nil
+	}
+	for i := range d.events {
+		ev := &d.events[i]
+		if ev.Anonymous || ev.ID != l.Topics[0] {
+			continue
+		}
+		indexed := 0
+		for _, input := range ev.Inputs {
+			if input.Indexed {
+				indexed++
+			}
+		}
+		if indexed == len(l.Topics)-1 {
+			return ev
+		}
+	}
+	return nil
+}
+
+// decode 解析认识的日志, 不认识的跳过, 原始日志仍然在 receipt 里
+func (d *logDecoder) decode(logs []*types.Log) []DecodedLog {
+	var decoded []DecodedLog
+	for _, l := range logs {
+		ev := d.match(l)
+		if ev == nil {
+			continue
+		}
+
+		var indexed abi.Arguments
+		for _, input := range ev.Inputs {
+			if input.Indexed {
+				indexed = append(indexed, input)
+			}
+		}
+
+		args := make(map[string]interface{})
+		if err := ev.Inputs.UnpackIntoMap(args, l.Data); err != nil {
+			continue
+		}
+		if err := abi.ParseTopicsIntoMap(args, indexed, l.Topics[1:]); err != nil {
+			continue
+		}
+		decoded = append(decoded, DecodedLog{Log: l, Name: ev.Name, Args: args})
+	}
+	return decoded
+}
@@ -0,0 +1,103 @@
+package blx
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// NetworkConfig describes a chain the Jk talks to.
+type NetworkConfig struct {
+	Name      string
+	Endpoints []string
+	// WsEndpoints 可选, 配置后用推送的新区块驱动扫块和等回执
+	WsEndpoints []string
+	// ChainId 为 0 时, 首次使用时从节点读取
+	ChainId       int64
+	Coin          string
+	Decimals      uint8
+	Confirmations uint64
+	// Multicall 可选, Multicall3 合约地址, 配置后批量查余额合并成一个 eth_call
+	Multicall string
+}
+
+var MainNetConfig = &NetworkConfig{
+	Name:          "mainnet",
+	Endpoints:     []string{MainNet, MainNet2},
+	ChainId:       MainNetChainId,
+	Coin:          MainNetCoin,
+	Decimals:      18,
+	Confirmations: 12,
+}
+
+var TestNetConfig = &NetworkConfig{
+	Name:          "testnet",
+	Endpoints:     []string{TestNet},
+	Coin:          MainNetCoin,
+	Decimals:      18,
+	Confirmations: 3,
+}
+
+var (
+	networksMu sync.RWMutex
+	networks   = map[string]*NetworkConfig{
+		MainNetConfig.Name: MainNetConfig,
+		TestNetConfig.Name: TestNetConfig,
+	}
+)
+
+// RegisterNetwork adds a custom profile, replacing any profile with the same name.
+func RegisterNetwork(cfg *NetworkConfig) error {
+	if cfg == nil || cfg.Name == "" {
+		return errors.New("network name can not be empty")
+	}
+	if len(cfg.Endpoints) == 0 {
+		return errors.New("network endpoints can not be empty")
+	}
+
+	networksMu.Lock()
+	defer networksMu.Unlock()
+	networks[cfg.Name] = cfg
+	return nil
+}
+
+func GetNetwork(name string) (*NetworkConfig, bool) {
+	networksMu.RLock()
+	defer networksMu.RUnlock()
+	cfg, ok := networks[name]
+	return cfg, ok
+}
+
+// NetworkForEndpoint returns the registered profile serving url. Unknown urls get
+// an ad hoc profile whose chain id is read from the node.
+func NetworkForEndpoint(url string) *NetworkConfig {
+	networksMu.RLock()
+	defer networksMu.RUnlock()
+
+	for _, cfg := range networks {
+		for _, endpoint := range cfg.Endpoints {
+			if strings.EqualFold(strings.TrimRight(endpoint, "/"), strings.TrimRight(url, "/")) {
+				return cfg
+			}
+		}
+	}
+
+	return &NetworkConfig{
+		Name:      url,
+		Endpoints: []string{url},
+		Coin:      MainNetCoin,
+		Decimals:  18,
+	}
+}
+
+// withEndpoints returns a copy of the profile that dials only the given urls.
+func (n *NetworkConfig) withEndpoints(urls ...string) *NetworkConfig {
+	cp := *n
+	cp.Endpoints = urls
+	return &cp
+}
+
+func (n *NetworkConfig) coinDecimal() *big.Float {
+	return new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n.Decimals)), nil))
+}
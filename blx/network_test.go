@@ -0,0 +1,51 @@
+package blx
+
+import "testing"
+
+// restoreNetworks puts the registry back after the test, so registered profiles do not leak
+// into the next run.
+func restoreNetworks(t *testing.T) {
+	networksMu.Lock()
+	saved := make(map[string]*NetworkConfig, len(networks))
+	for name, cfg := range networks {
+		saved[name] = cfg
+	}
+	networksMu.Unlock()
+
+	t.Cleanup(func() {
+		networksMu.Lock()
+		networks = saved
+		networksMu.Unlock()
+	})
+}
+
+func TestNetworkForEndpoint(t *testing.T) {
+	restoreNetworks(t)
+
+	if cfg := NetworkForEndpoint(MainNet2); cfg != MainNetConfig {
+		t.Error("MainNet2 should resolve to mainnet profile, got ", cfg.Name)
+	}
+
+	if cfg := NetworkForEndpoint(TestNet + "/"); cfg != TestNetConfig {
+		t.Error("TestNet should resolve to testnet profile, got ", cfg.Name)
+	}
+
+	local := "http://127.0.0.1:8545"
+	cfg := NetworkForEndpoint(local)
+	if cfg.ChainId != 0 || cfg.Endpoints[0] != local {
+		t.Error("unknown endpoint should get an ad hoc profile")
+	}
+
+	err := RegisterNetwork(&NetworkConfig{Name: "devnet", Endpoints: []string{local}, ChainId: 1337, Decimals: 18})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg := NetworkForEndpoint(local); cfg.ChainId != 1337 {
+		t.Error("registered devnet not resolved")
+	}
+
+	if err := RegisterNetwork(&NetworkConfig{Name: "empty"}); err == nil {
+		t.Error("network without endpoints should be rejected")
+	}
+}
@@ -0,0 +1,238 @@
+package blx
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+func (j *Jk) erc721(contractAddr string) (*Contract, error) {
+	if contractAddr == "" {
+		return nil, ContractNotEmpty
+	}
+	return j.BindContract(contractAddr, AbiErc721)
+}
+
+func (j *Jk) erc1155(contractAddr string) (*Contract, error) {
+	if contractAddr == "" {
+		return nil, ContractNotEmpty
+	}
+	return j.BindContract(contractAddr, AbiErc1155)
+}
+
+// Erc721OwnerOf returns the owner of the ERC-721 token tokenID.
+func (j *Jk) Erc721OwnerOf(ctx context.Context, contractAddr string, tokenID *big.Int, at ...BlockRef) (common.Address, error) {
+	token, err := j.erc721(contractAddr)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	out, err := token.CallAt(ctx, blockOf(at), "ownerOf", tokenID)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return out[0].(common.Address), nil
+}
+
+// Erc721BalanceOf returns how many tokens of the ERC-721 contract owner holds.
+func (j *Jk) Erc721BalanceOf(ctx context.Context, contractAddr string, owner string, at ...BlockRef) (*big.Int, error) {
+	token, err := j.erc721(contractAddr)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := hexAddresses(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := token.CallAt(ctx, blockOf(at), "balanceOf", addresses[0])
+	if err != nil {
+		return nil, err
+	}
+	return out[0].(*big.Int), nil
+}
+
+func (j *Jk) Erc721TokenURI(ctx context.Context, contractAddr string, tokenID *big.Int, at ...BlockRef) (string, error) {
+	token, err := j.erc721(contractAddr)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := token.CallAt(ctx, blockOf(at), "tokenURI", tokenID)
+	if err != nil {
+		return "", err
+	}
+	return out[0].(string), nil
+}
+
+// Erc721SafeTransferFromSync moves tokenID from owner to receive and waits for the receipt. The
+// signer is the owner or an approved operator.
+func (j *Jk) Erc721SafeTransferFromSync(ctx context.Context, signer Signer, contractAddr string, owner string, receive string, tokenID *big.Int) (hash string, err error) {
+	return j.erc721Transfer(ctx, signer, contractAddr, owner, receive, tokenID, true)
+}
+
+// Erc721SafeTransferFromAsync is Erc721SafeTransferFromSync without waiting for the receipt.
+func (j *Jk) Erc721SafeTransferFromAsync(ctx context.Context, signer Signer, contractAddr string, owner string, receive string, tokenID *big.Int) (hash string, err error) {
+	return j.erc721Transfer(ctx, signer, contractAddr, owner, receive, tokenID, false)
+}
+
+func (j *Jk) erc721Transfer(ctx context.Context, signer Signer, contractAddr string, owner string, receive string, tokenID *big.Int, wait bool) (string, error) {
+	if tokenID == nil || tokenID.Sign() < 0 {
+		return "", AmountError.With("tokenID", tokenID)
+	}
+	token, err := j.erc721(contractAddr)
+	if err != nil {
+		return "", err
+	}
+	addresses, err := hexAddresses(owner, receive)
+	if err != nil {
+		return "", err
+	}
+
+	current, err := j.Erc721OwnerOf(ctx, contractAddr, tokenID)
+	if err != nil {
+		return "", err
+	}
+	if current != addresses[0] {
+		return "", BalanceLessAmountError.With("address", owner).With("token", contractAddr).With("tokenID", tokenID).With("owner", current.Hex())
+	}
+
+	//safeTransferFrom 有两个重载, 不带 data 的是 safeTransferFrom
+	if wait {
+		return token.Transact(ctx, signer, "safeTransferFrom", nil, addresses[0], addresses[1], tokenID)
+	}
+	return token.TransactAsync(ctx, signer, "safeTransferFrom", nil, addresses[0], addresses[1], tokenID)
+}
+
+// Erc1155BalanceOf returns how many of the ERC-1155 token id owner holds.
+func (j *Jk) Erc1155BalanceOf(ctx context.Context, contractAddr string, owner string, id *big.Int, at ...BlockRef) (*big.Int, error) {
+	token, err := j.erc1155(contractAddr)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := hexAddresses(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := token.CallAt(ctx, blockOf(at), "balanceOf", addresses[0], id)
+	if err != nil {
+		return nil, err
+	}
+	return out[0].(*big.Int), nil
+}
+
+// Erc1155URI returns the metadata URI of id, clients substitute {id} themselves.
+func (j *Jk) Erc1155URI(ctx context.Context, contractAddr string, id *big.Int, at ...BlockRef) (string, error) {
+	token, err := j.erc1155(contractAddr)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := token.CallAt(ctx, blockOf(at), "uri", id)
+	if err != nil {
+		return "", err
+	}
+	return out[0].(string), nil
+}
+
+// Erc1155SafeTransferFromSync moves amount of id from owner to receive and waits for the receipt.
+// data is passed to the receiver hook and may be nil.
+func (j *Jk) Erc1155SafeTransferFromSync(ctx context.Context, signer Signer, contractAddr string, owner string, receive string, id *big.Int, amount *big.Int, data []byte) (hash string, err error) {
+	return j.erc1155Transfer(ctx, signer, contractAddr, owner, receive, []*big.Int{id}, []*big.Int{amount}, data, true)
+}
+
+// Erc1155SafeTransferFromAsync is Erc1155SafeTransferFromSync without waiting for the receipt.
+func (j *Jk) Erc1155SafeTransferFromAsync(ctx context.Context, signer Signer, contractAddr string, owner string, receive string, id *big.Int, amount *big.Int, data []byte) (hash string, err error) {
+	return j.erc1155Transfer(ctx, signer, contractAddr, owner, receive, []*big.Int{id}, []*big.Int{amount}, data, false)
+}
+
+// Erc1155SafeBatchTransferFromSync moves amounts[i] of ids[i] from owner to receive in one
+// transaction and waits for the receipt.
+func (j *Jk) Erc1155SafeBatchTransferFromSync(ctx context.Context, signer Signer, contractAddr string, owner string, receive string, ids []*big.Int, amounts []*big.Int, data []byte) (hash string, err error) {
+	return j.erc1155Transfer(ctx, signer, contractAddr, owner, receive, ids, amounts, data, true)
+}
+
+// Erc1155SafeBatchTransferFromAsync is Erc1155SafeBatchTransferFromSync without waiting for the receipt.
+func (j *Jk) Erc1155SafeBatchTransferFromAsync(ctx context.Context, signer Signer, contractAddr string, owner string, receive string, ids []*big.Int, amounts []*big.Int, data []byte) (hash string, err error) {
+	return j.erc1155Transfer(ctx, signer, contractAddr, owner, receive, ids, amounts, data, false)
+}
+
+func (j *Jk) erc1155Transfer(ctx context.Context, signer Signer, contractAddr string, owner string, receive string, ids []*big.Int, amounts []*big.Int, data []byte, wait bool) (string, error) {
+	if len(ids) == 0 || len(ids) != len(amounts) {
+		return "", AmountError.With("ids", len(ids)).With("amounts", len(amounts))
+	}
+	for i := range ids {
+		if ids[i] == nil || amounts[i] == nil || ids[i].Sign() < 0 || amounts[i].Sign() <= 0 {
+			return "", AmountError.With("id", ids[i]).With("amount", amounts[i])
+		}
+	}
+	token, err := j.erc1155(contractAddr)
+	if err != nil {
+		return "", err
+	}
+	addresses, err := hexAddresses(owner, receive)
+	if err != nil {
+		return "", err
+	}
+	if data == nil {
+		data = []byte{}
+	}
+
+	for i := range ids {
+		balance, err := j.Erc1155BalanceOf(ctx, contractAddr, owner, ids[i])
+		if err != nil {
+			return "", err
+		}
+		if balance.Cmp(amounts[i]) < 0 {
+			return "", BalanceLessAmountError.With("address", owner).With("token", contractAddr).With("id", ids[i]).With("need", amounts[i]).With("available", balance)
+		}
+	}
+
+	method, args := "safeBatchTransferFrom", []interface{}{addresses[0], addresses[1], ids, amounts, data}
+	if len(ids) == 1 {
+		method, args = "safeTransferFrom", []interface{}{addresses[0], addresses[1], ids[0], amounts[0], data}
+	}
+	if wait {
+		return token.Transact(ctx, signer, method, nil, args...)
+	}
+	return token.TransactAsync(ctx, signer, method, nil, args...)
+}
+
+// SetApprovalForAllSync lets operator move all of the signer's tokens of an ERC-721 or ERC-1155
+// contract, or revokes it, and waits for the receipt. Both standards share the method.
+func (j *Jk) SetApprovalForAllSync(ctx context.Context, signer Signer, contractAddr string, operator string, approved bool) (hash string, err error) {
+	return j.setApprovalForAll(ctx, signer, contractAddr, operator, approved, true)
+}
+
+// SetApprovalForAllAsync is SetApprovalForAllSync without waiting for the receipt.
+func (j *Jk) SetApprovalForAllAsync(ctx context.Context, signer Signer, contractAddr string, operator string, approved bool) (hash string, err error) {
+	return j.setApprovalForAll(ctx, signer, contractAddr, operator, approved, false)
+}
+
+func (j *Jk) setApprovalForAll(ctx context.Context, signer Signer, contractAddr string, operator string, approved bool, wait bool) (string, error) {
+	token, err := j.erc721(contractAddr)
+	if err != nil {
+		return "", err
+	}
+	addresses, err := hexAddresses(operator)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := token.Call(ctx, "isApprovedForAll", signer.Address(), addresses[0])
+	if err != nil {
+		return "", err
+	}
+	if out[0].(bool) == approved {
+		log.Log.Debug("approval for all of ", operator, " already ", approved)
+		return "", nil
+	}
+
+	if wait {
+		return token.Transact(ctx, signer, "setApprovalForAll", nil, addresses[0], approved)
+	}
+	return token.TransactAsync(ctx, signer, "setApprovalForAll", nil, addresses[0], approved)
+}
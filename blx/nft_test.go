@@ -0,0 +1,118 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+func TestNftSurface(t *testing.T) {
+	node := newTestNode(t)
+	nft := common.HexToAddress("0xaa")
+	multi := common.HexToAddress("0xbb")
+	erc721, err := abi.JSON(strings.NewReader(AbiErc721))
+	if err != nil {
+		t.Fatal(err)
+	}
+	erc1155, err := abi.JSON(strings.NewReader(AbiErc1155))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := func(parsed abi.ABI, to common.Address, method string, values ...interface{}) {
+		out, err := parsed.Methods[method].Outputs.Pack(values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.eth.setMethodResult(to, parsed.Methods[method], out)
+	}
+
+	key, _ := crypto.GenerateKey()
+	signer := NewKeySigner(key)
+	bob := common.HexToAddress("0xb0")
+
+	set(erc721, nft, "ownerOf", signer.Address())
+	set(erc721, nft, "balanceOf", big.NewInt(2))
+	set(erc721, nft, "tokenURI", "ipfs://7")
+	set(erc721, nft, "isApprovedForAll", false)
+	set(erc1155, multi, "balanceOf", big.NewInt(10))
+	set(erc1155, multi, "uri", "ipfs://{id}")
+	set(erc1155, multi, "isApprovedForAll", false)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel), WithLegacyTx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+	ctx := context.Background()
+
+	owner, err := jk.Erc721OwnerOf(ctx, nft.Hex(), big.NewInt(7))
+	if err != nil || owner != signer.Address() {
+		t.Fatal("owner ", owner.Hex(), err)
+	}
+	if balance, err := jk.Erc721BalanceOf(ctx, nft.Hex(), bob.Hex()); err != nil || balance.Int64() != 2 {
+		t.Fatal("balance ", balance, err)
+	}
+	if uri, err := jk.Erc721TokenURI(ctx, nft.Hex(), big.NewInt(7)); err != nil || uri != "ipfs://7" {
+		t.Fatal("token uri ", uri, err)
+	}
+	if uri, err := jk.Erc1155URI(ctx, multi.Hex(), big.NewInt(1)); err != nil || uri != "ipfs://{id}" {
+		t.Fatal("uri ", uri, err)
+	}
+
+	if _, err = jk.Erc721SafeTransferFromSync(ctx, signer, nft.Hex(), signer.Address().Hex(), bob.Hex(), big.NewInt(7)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = jk.Erc721SafeTransferFromSync(ctx, signer, nft.Hex(), bob.Hex(), signer.Address().Hex(), big.NewInt(7)); !errors.Is(err, BalanceLessAmountError) {
+		t.Fatal("transfer of a token the owner does not have: ", err)
+	}
+
+	if _, err = jk.Erc1155SafeTransferFromAsync(ctx, signer, multi.Hex(), signer.Address().Hex(), bob.Hex(), big.NewInt(1), big.NewInt(3), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = jk.Erc1155SafeBatchTransferFromSync(ctx, signer, multi.Hex(), signer.Address().Hex(), bob.Hex(), []*big.Int{big.NewInt(1), big.NewInt(2)}, []*big.Int{big.NewInt(4), big.NewInt(5)}, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = jk.Erc1155SafeTransferFromSync(ctx, signer, multi.Hex(), signer.Address().Hex(), bob.Hex(), big.NewInt(1), big.NewInt(11), nil); !errors.Is(err, BalanceLessAmountError) {
+		t.Fatal("transfer over the balance: ", err)
+	}
+	if _, err = jk.Erc1155SafeBatchTransferFromSync(ctx, signer, multi.Hex(), signer.Address().Hex(), bob.Hex(), []*big.Int{big.NewInt(1)}, nil, nil); !errors.Is(err, AmountError) {
+		t.Fatal("ids without amounts: ", err)
+	}
+
+	if _, err = jk.SetApprovalForAllSync(ctx, signer, multi.Hex(), bob.Hex(), true); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := jk.SetApprovalForAllAsync(ctx, signer, nft.Hex(), bob.Hex(), false); err != nil || hash != "" {
+		t.Fatal("revoking a missing approval sent ", hash, err)
+	}
+
+	sent := node.eth.sent
+	if len(sent) != 4 {
+		t.Fatalf("sent %d transactions", len(sent))
+	}
+	checks := []struct {
+		parsed abi.ABI
+		method string
+		to     common.Address
+	}{{erc721, "safeTransferFrom", nft}, {erc1155, "safeTransferFrom", multi}, {erc1155, "safeBatchTransferFrom", multi}, {erc1155, "setApprovalForAll", multi}}
+	for i, c := range checks {
+		m := c.parsed.Methods[c.method]
+		if *sent[i].To() != c.to || string(sent[i].Data()[:4]) != string(m.ID) {
+			t.Fatalf("transaction %d is not %s", i, c.method)
+		}
+		if _, err := m.Inputs.Unpack(sent[i].Data()[4:]); err != nil {
+			t.Fatalf("transaction %d: %v", i, err)
+		}
+	}
+	args, _ := erc1155.Methods["safeBatchTransferFrom"].Inputs.Unpack(sent[2].Data()[4:])
+	if ids := args[2].([]*big.Int); len(ids) != 2 || ids[1].Int64() != 2 {
+		t.Fatalf("unexpected batch args %v", args)
+	}
+}
@@ -0,0 +1,182 @@
+package blx
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// NonceManager hands out nonces per address locally, so concurrent sends from one account
+// neither race on PendingNonceAt nor need the caller to count.
+type NonceManager struct {
+	mu       sync.Mutex
+	accounts map[common.Address]*accountNonce
+	pending  func(ctx context.Context, address common.Address) (uint64, error)
+}
+
+type accountNonce struct {
+	mu     sync.Mutex
+	synced bool
+	next   uint64
+	//广播失败退回的 nonce, 从小到大
+	gaps []uint64
+}
+
+func NewNonceManager(pending func(ctx context.Context, address common.Address) (uint64, error)) *NonceManager {
+	return &NonceManager{accounts: make(map[common.Address]*accountNonce), pending: pending}
+}
+
+func (m *NonceManager) account(address common.Address) *accountNonce {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.accounts[address]
+	if !ok {
+		a = &accountNonce{}
+		m.accounts[address] = a
+	}
+	return a
+}
+
+// Next returns the lowest returned nonce, or the next unused one. The first call per
+// address, and the first after Resync, reads the pending nonce from the chain.
+func (m *NonceManager) Next(ctx context.Context, address common.Address) (uint64, error) {
+	a := m.account(address)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.synced {
+		n, err := m.pending(ctx, address)
+		if err != nil {
+			return 0, err
+		}
+		a.next = n
+		a.gaps = nil
+		a.synced = true
+	}
+
+	if len(a.gaps) > 0 {
+		n := a.gaps[0]
+		a.gaps = a.gaps[1:]
+		return n, nil
+	}
+
+	n := a.next
+	a.next++
+	return n, nil
+}
+
+// Release gives back a nonce whose transaction never reached the node.
+func (m *NonceManager) Release(address common.Address, nonce uint64) {
+	a := m.account(address)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.synced || nonce >= a.next {
+		return
+	}
+
+	if nonce == a.next-1 {
+		a.next--
+		for len(a.gaps) > 0 && a.gaps[len(a.gaps)-1] == a.next-1 {
+			a.gaps = a.gaps[:len(a.gaps)-1]
+			a.next--
+		}
+		return
+	}
+
+	i := sort.Search(len(a.gaps), func(i int) bool { return a.gaps[i] >= nonce })
+	if i < len(a.gaps) && a.gaps[i] == nonce {
+		return
+	}
+	a.gaps = append(a.gaps, 0)
+	copy(a.gaps[i+1:], a.gaps[i:])
+	a.gaps[i] = nonce
+}
+
+// Observe records a nonce the caller chose itself, so it is not handed out again.
+func (m *NonceManager) Observe(address common.Address, nonce uint64) {
+	a := m.account(address)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.synced {
+		return
+	}
+
+	for i, n := range a.gaps {
+		if n == nonce {
+			a.gaps = append(a.gaps[:i], a.gaps[i+1:]...)
+			break
+		}
+	}
+	if nonce >= a.next {
+		a.next = nonce + 1
+	}
+}
+
+// Resync drops local state, the next nonce is read from the chain again.
+func (m *NonceManager) Resync(address common.Address) {
+	a := m.account(address)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.synced = false
+	a.gaps = nil
+}
+
+// isNonceError 节点的 nonce 和本地不一致. 交易已经在交易池里不算, 那个 nonce 确实用掉了
+func isNonceError(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "nonce too low")
+}
+
+func (j *Jk) Nonces() *NonceManager {
+	return j.nonces
+}
+
+// takeNonce 取一个 nonce, 广播后用返回的 done 回报结果. nonce 为空时由 NonceManager 分配
+func (j *Jk) takeNonce(ctx context.Context, from common.Address, nonce *uint64) (uint64, func(err error), error) {
+	if nonce != nil {
+		n := *nonce
+		return n, func(err error) {
+			if err == nil || isAlreadyKnown(err) {
+				j.nonces.Observe(from, n)
+			} else if isNonceError(err) {
+				j.nonces.Resync(from)
+			}
+		}, nil
+	}
+
+	n, err := j.nonces.Next(ctx, from)
+	if err != nil {
+		log.Log.Error("get pendingNonce err: ", err)
+		return 0, nil, err
+	}
+
+	return n, func(err error) {
+		if err == nil || isAlreadyKnown(err) {
+			return
+		}
+		if isNonceError(err) {
+			log.Log.Warn("nonce ", n, " of ", from.Hex(), " out of sync, resync: ", err)
+			j.nonces.Resync(from)
+			return
+		}
+		j.nonces.Release(from, n)
+	}, nil
+}
+
+// managedNonce 旧接口里 nonce 0 表示自动分配
+func managedNonce(nonce uint64) *uint64 {
+	if nonce == 0 {
+		return nil
+	}
+	return &nonce
+}
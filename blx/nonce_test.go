@@ -0,0 +1,159 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+func TestNonceManager(t *testing.T) {
+	log.Init(logrus.ErrorLevel)
+
+	chain := uint64(5)
+	fetches := 0
+	m := NewNonceManager(func(ctx context.Context, address common.Address) (uint64, error) {
+		fetches++
+		return chain, nil
+	})
+	ctx := context.Background()
+	addr := common.HexToAddress("0x1")
+
+	next := func() uint64 {
+		n, err := m.Next(ctx, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	for want := uint64(5); want < 8; want++ {
+		if n := next(); n != want {
+			t.Fatalf("nonce %v, want %v", n, want)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("fetched %v times", fetches)
+	}
+
+	//6 广播失败, 下一个先补上 6
+	m.Release(addr, 6)
+	if n := next(); n != 6 {
+		t.Fatalf("gap not filled, got %v", n)
+	}
+	if n := next(); n != 8 {
+		t.Fatalf("nonce %v, want 8", n)
+	}
+
+	//最高的退回时直接回退
+	m.Release(addr, 8)
+	if n := next(); n != 8 {
+		t.Fatalf("nonce %v, want 8", n)
+	}
+
+	m.Observe(addr, 20)
+	if n := next(); n != 21 {
+		t.Fatalf("nonce %v, want 21 after observe", n)
+	}
+
+	chain = 30
+	m.Resync(addr)
+	if n := next(); n != 30 || fetches != 2 {
+		t.Fatalf("nonce %v fetches %v after resync", n, fetches)
+	}
+}
+
+func TestNonceManagerConcurrent(t *testing.T) {
+	m := NewNonceManager(func(ctx context.Context, address common.Address) (uint64, error) {
+		return 0, nil
+	})
+	addr := common.HexToAddress("0x2")
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[uint64]bool)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := m.Next(context.Background(), addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[n] {
+				t.Errorf("nonce %v handed out twice", n)
+			}
+			seen[n] = true
+		}()
+	}
+	wg.Wait()
+
+	for n := uint64(0); n < 100; n++ {
+		if !seen[n] {
+			t.Fatalf("nonce %v skipped", n)
+		}
+	}
+}
+
+func TestIsNonceError(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"nonce too low":                       true,
+		"already known":                       false,
+		"known transaction: 0xabc":            false,
+		"insufficient funds for gas":          false,
+		"replacement transaction underpriced": false,
+	} {
+		if got := isNonceError(errors.New(msg)); got != want {
+			t.Errorf("isNonceError(%q) = %v", msg, got)
+		}
+	}
+}
+
+func TestDroppedTxResyncsNonce(t *testing.T) {
+	node := newTestNode(t)
+	node.eth.mu.Lock()
+	node.eth.poolSends = true
+	node.eth.mu.Unlock()
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel), WithLegacyTx(),
+		WithWaitOptions(WaitOptions{PollInterval: 10 * time.Millisecond, Timeout: 2 * time.Second}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	key, _ := crypto.GenerateKey()
+	signer := NewKeySigner(key)
+	go func() {
+		//交易进了交易池之后被丢弃
+		for {
+			time.Sleep(30 * time.Millisecond)
+			node.eth.mu.Lock()
+			sent := node.eth.sent
+			node.eth.mu.Unlock()
+			if len(sent) > 0 {
+				node.eth.dropPending(sent[0].Hash())
+				return
+			}
+		}
+	}()
+
+	_, err = jk.SendSyncBig(context.Background(), signer, common.HexToAddress("0xd1").Hex(), big.NewInt(1))
+	if !errors.Is(err, TransactionDroppedError) {
+		t.Fatalf("err %v, want TransactionDroppedError", err)
+	}
+	//nonce 0 没有上链, 重新从链上读到 0, 不会一直卡在空洞后面
+	if n, err := jk.Nonces().Next(context.Background(), signer.Address()); err != nil || n != 0 {
+		t.Fatalf("next nonce %d, %v, want 0 after resync", n, err)
+	}
+}
@@ -0,0 +1,203 @@
+package blx
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Option func(*options)
+
+// Balancer decides which endpoint serves the next connection.
+type Balancer int
+
+const (
+	RoundRobin Balancer = iota
+	LowestLatency
+)
+
+type options struct {
+	network           *NetworkConfig
+	size              int
+	maxIdle           int
+	maxOpen           int
+	dialTimeout       time.Duration
+	healthInterval    time.Duration
+	healthTimeout     time.Duration
+	balancer          Balancer
+	cooldown          time.Duration
+	maxCooldown       time.Duration
+	maxAttempts       int
+	legacyTx          bool
+	baseFeeMultiplier float64
+	tipMultiplier     float64
+	level             logrus.Level
+	wait              WaitOptions
+	errorABIs         []string
+	tokenTTL          time.Duration
+	preloadTokens     []string
+}
+
+func defaultOptions() *options {
+	return &options{
+		network:           MainNetConfig,
+		size:              3,
+		dialTimeout:       10 * time.Second,
+		healthInterval:    30 * time.Second,
+		healthTimeout:     5 * time.Second,
+		cooldown:          time.Second,
+		maxCooldown:       time.Minute,
+		baseFeeMultiplier: 2,
+		tipMultiplier:     1,
+		level:             logrus.InfoLevel,
+		tokenTTL:          time.Hour,
+		wait: WaitOptions{
+			PollInterval: MaxRetryTimeDurationSeconds * time.Second,
+			Timeout:      MaxRetrySync * MaxRetryTimeDurationSeconds * time.Second,
+		},
+	}
+}
+
+func WithNetwork(network *NetworkConfig) Option {
+	return func(o *options) {
+		o.network = network
+	}
+}
+
+// WithEndpoint dials url with the registered profile that serves it.
+func WithEndpoint(url string) Option {
+	return func(o *options) {
+		o.network = NetworkForEndpoint(url).withEndpoints(url)
+	}
+}
+
+// WithEndpoints spreads the pool over several urls of the profile serving the first one.
+func WithEndpoints(urls ...string) Option {
+	return func(o *options) {
+		if len(urls) == 0 {
+			return
+		}
+		o.network = NetworkForEndpoint(urls[0]).withEndpoints(urls...)
+	}
+}
+
+// WithWsEndpoints adds websocket urls for subscriptions to the network profile.
+func WithWsEndpoints(urls ...string) Option {
+	return func(o *options) {
+		cp := *o.network
+		cp.WsEndpoints = urls
+		o.network = &cp
+	}
+}
+
+// WithMulticall sets the Multicall3 contract GetBalances and GetTokenBalances aggregate their
+// calls with.
+func WithMulticall(address string) Option {
+	return func(o *options) {
+		cp := *o.network
+		cp.Multicall = address
+		o.network = &cp
+	}
+}
+
+// WithPoolSize sets how many connections are dialed up front.
+func WithPoolSize(size int) Option {
+	return func(o *options) {
+		o.size = size
+	}
+}
+
+// WithMaxIdle caps the idle connections kept per endpoint, defaults to the pool size.
+func WithMaxIdle(n int) Option {
+	return func(o *options) {
+		o.maxIdle = n
+	}
+}
+
+// WithMaxOpen caps the connections in use plus idle, 0 means unlimited.
+func WithMaxOpen(n int) Option {
+	return func(o *options) {
+		o.maxOpen = n
+	}
+}
+
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.dialTimeout = timeout
+	}
+}
+
+// WithHealthCheck probes idle connections every interval, 0 disables it.
+func WithHealthCheck(interval, timeout time.Duration) Option {
+	return func(o *options) {
+		o.healthInterval = interval
+		o.healthTimeout = timeout
+	}
+}
+
+func WithBalancer(balancer Balancer) Option {
+	return func(o *options) {
+		o.balancer = balancer
+	}
+}
+
+// WithCooldown sets how long a failing endpoint is skipped, doubling per failure up to max.
+func WithCooldown(base, max time.Duration) Option {
+	return func(o *options) {
+		o.cooldown = base
+		o.maxCooldown = max
+	}
+}
+
+// WithMaxAttempts limits how many endpoints a call tries, defaults to the endpoint count + 1.
+func WithMaxAttempts(n int) Option {
+	return func(o *options) {
+		o.maxAttempts = n
+	}
+}
+
+// WithFeeMultipliers sets maxFeePerGas of EIP-1559 transactions to
+// baseFee×baseFeeMultiplier + tip×tipMultiplier, the priority fee sent is tip×tipMultiplier.
+func WithFeeMultipliers(baseFeeMultiplier, tipMultiplier float64) Option {
+	return func(o *options) {
+		o.baseFeeMultiplier = baseFeeMultiplier
+		o.tipMultiplier = tipMultiplier
+	}
+}
+
+// WithLegacyTx always sends legacy gas price transactions.
+func WithLegacyTx() Option {
+	return func(o *options) {
+		o.legacyTx = true
+	}
+}
+
+// WithWaitOptions sets how the sync send APIs and WaitMined without options wait for receipts,
+// by default every second for at most MaxRetrySync seconds.
+func WithWaitOptions(wait WaitOptions) Option {
+	return func(o *options) {
+		o.wait = wait
+	}
+}
+
+// WithErrorABI adds the custom errors of abiJSON to the decoding of revert data.
+func WithErrorABI(abiJSON string) Option {
+	return func(o *options) {
+		o.errorABIs = append(o.errorABIs, abiJSON)
+	}
+}
+
+// WithTokenCache sets how long token name, symbol and decimals are cached, 1 hour by default and
+// forever with 0. tokens are read while the Jk is created.
+func WithTokenCache(ttl time.Duration, tokens ...string) Option {
+	return func(o *options) {
+		o.tokenTTL = ttl
+		o.preloadTokens = append(o.preloadTokens, tokens...)
+	}
+}
+
+func WithLogLevel(level logrus.Level) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
@@ -0,0 +1,600 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sirupsen/logrus"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+type Jk struct {
+	endpoints []*endpoint
+	owners    sync.Map
+	next      uint64
+	slots     chan struct{}
+	opts      *options
+	m         sync.Mutex
+	rw        sync.Mutex
+	closed    bool
+	done      chan struct{}
+	net       *NetworkConfig
+	chainID   *big.Int
+	nonces    *NonceManager
+	heads     *headFeed
+	errorABIs []abi.ABI
+	tokens    *TokenRegistry
+}
+
+// endpoint 一个节点地址和它的空闲连接
+type endpoint struct {
+	url  string
+	idle chan *ethclient.Client
+
+	mu            sync.Mutex
+	failures      int
+	cooldownUntil time.Time
+	latency       time.Duration
+}
+
+// pooledConn 记录连接属于哪个节点, 以及底层的 rpc 连接
+type pooledConn struct {
+	ep  *endpoint
+	rpc *rpc.Client
+}
+
+// NewJk dials net, using the registered profile that serves it for chain id and coin settings.
+//
+// Deprecated: use NewJkWithOptions, which reports unreachable nodes.
+func NewJk(size int, net string, level logrus.Level) *Jk {
+	return NewJkWithNetwork(size, NetworkForEndpoint(net).withEndpoints(net), level)
+}
+
+// NewJkWithNetwork keeps the old behaviour of returning a pool even if no node answered,
+// connections are dialed again on Acquire. Idle connections are not health checked, so callers
+// that never Close the pool do not leak a goroutine.
+func NewJkWithNetwork(size int, network *NetworkConfig, level logrus.Level) *Jk {
+	//旧接口的调用方不会调用 Close, 不启动后台的健康检查
+	noHealthCheck := func(o *options) { o.healthInterval = 0 }
+	j, err := newJk(context.Background(), WithNetwork(network), WithPoolSize(size), WithLogLevel(level), noHealthCheck)
+	if err != nil {
+		log.Log.Error("init eth client pool: ", err)
+	}
+	return j
+}
+
+// NewJkWithOptions dials the pool and fails when no connection passes a liveness probe.
+func NewJkWithOptions(ctx context.Context, opts ...Option) (*Jk, error) {
+	j, err := newJk(ctx, opts...)
+	if err != nil {
+		j.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func newJk(ctx context.Context, opts ...Option) (*Jk, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.size <= 0 {
+		o.size = 3
+	}
+	if o.maxIdle <= 0 {
+		o.maxIdle = o.size
+	}
+	if o.maxOpen > 0 && o.maxIdle > o.maxOpen {
+		o.maxIdle = o.maxOpen
+	}
+
+	log.Init(o.level)
+
+	j := &Jk{
+		opts: o,
+		done: make(chan struct{}),
+		net:  o.network,
+	}
+	j.heads = newHeadFeed(j)
+	j.nonces = NewNonceManager(func(ctx context.Context, address common.Address) (uint64, error) {
+		return j.GetPendingNonce(ctx, address.Hex())
+	})
+	tokens, err := NewTokenRegistry(o.tokenTTL, j.callContract)
+	if err != nil {
+		return j, err
+	}
+	j.tokens = tokens
+	for _, abiJSON := range o.errorABIs {
+		parsed, err := abi.JSON(strings.NewReader(abiJSON))
+		if err != nil {
+			return j, err
+		}
+		j.errorABIs = append(j.errorABIs, parsed)
+	}
+	for _, url := range o.network.Endpoints {
+		j.endpoints = append(j.endpoints, &endpoint{url: url, idle: make(chan *ethclient.Client, o.maxIdle)})
+	}
+	if len(j.endpoints) == 0 {
+		return j, NoHealthyConnectionError
+	}
+	if o.maxOpen > 0 {
+		j.slots = make(chan struct{}, o.maxOpen)
+	}
+
+	//按节点轮流建立初始连接
+	healthy := 0
+	for i := 0; i < o.size; i++ {
+		ep := j.endpoints[i%len(j.endpoints)]
+		if len(ep.idle) == cap(ep.idle) {
+			continue
+		}
+		if !j.takeSlot() {
+			break
+		}
+
+		ec, err := j.dial(ctx, ep)
+		if err != nil {
+			j.freeSlot()
+			continue
+		}
+
+		err = j.probe(ctx, ec)
+		if err != nil {
+			log.Log.Error("probe eth ", ep.url, " error", err)
+			j.discard(ec)
+			continue
+		}
+
+		ep.idle <- ec
+		healthy++
+	}
+
+	if o.healthInterval > 0 {
+		go j.healthCheck()
+	}
+
+	if healthy == 0 {
+		return j, NoHealthyConnectionError
+	}
+
+	preload, err := hexAddresses(o.preloadTokens...)
+	if err != nil {
+		return j, err
+	}
+	if err = j.tokens.Preload(ctx, preload...); err != nil {
+		return j, err
+	}
+	return j, nil
+}
+
+func (j *Jk) Acquire() (*ethclient.Client, error) {
+	return j.AcquireContext(context.Background())
+}
+
+// AcquireContext takes a connection to the endpoint chosen by the balancer, skipping endpoints
+// that are cooling down after errors. It waits for a release when the pool is at its max open limit.
+func (j *Jk) AcquireContext(ctx context.Context) (*ethclient.Client, error) {
+	if len(j.endpoints) == 0 {
+		return nil, NoHealthyConnectionError
+	}
+	ep := j.pick()
+
+	select {
+	case r, ok := <-ep.idle:
+		if !ok {
+			return nil, PoolClosedError
+		}
+		log.Log.Debug("从池子里获取连接了")
+		return r, nil
+	default:
+	}
+
+	if j.slots == nil {
+		log.Log.Debug("需要新建资源了")
+		return j.dial(ctx, ep)
+	}
+
+	//连接数满了, 关掉其他节点的空闲连接腾出位置
+	if len(j.slots) == cap(j.slots) {
+		j.evictIdle(ep)
+	}
+
+	select {
+	case r, ok := <-ep.idle:
+		if !ok {
+			return nil, PoolClosedError
+		}
+		log.Log.Debug("从池子里获取连接了")
+		return r, nil
+	case j.slots <- struct{}{}:
+		log.Log.Debug("需要新建资源了")
+		r, err := j.dial(ctx, ep)
+		if err != nil {
+			j.freeSlot()
+			return nil, err
+		}
+		return r, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-j.done:
+		return nil, PoolClosedError
+	}
+}
+
+func (j *Jk) Release(r *ethclient.Client) {
+	if r == nil {
+		return
+	}
+
+	//保证该操作和Close方法的操作是安全的
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	//资源池都关闭了，就省这一个没有释放的资源了，释放即可
+	if j.closed {
+		j.discard(r)
+		return
+	}
+
+	ep := j.endpointOf(r)
+	if ep == nil {
+		r.Close()
+		return
+	}
+
+	select {
+	case ep.idle <- r:
+		log.Log.Debug("资源释放到池子里了")
+	default:
+		log.Log.Debug("资源池满了，释放这个资源吧")
+		j.discard(r)
+	}
+}
+
+// releaseWithError releases r, or drops it and cools its endpoint down when err says the node is unreachable.
+func (j *Jk) releaseWithError(r *ethclient.Client, err error) {
+	if r == nil {
+		return
+	}
+
+	if !isEndpointError(err) {
+		j.Release(r)
+		return
+	}
+
+	if ep := j.endpointOf(r); ep != nil {
+		j.markFailure(ep, err)
+	}
+	j.discard(r)
+}
+
+// withClient runs fn on a pooled connection and retries on another endpoint when the node is unreachable.
+func (j *Jk) withClient(ctx context.Context, fn func(client *ethclient.Client) error) error {
+	var err error
+	for attempt := 0; attempt < j.maxAttempts(); attempt++ {
+		var client *ethclient.Client
+		client, err = j.AcquireContext(ctx)
+		if err != nil {
+			//连不上的节点已经冷却, 换下一个节点
+			if ctx.Err() != nil || errors.Is(err, PoolClosedError) || errors.Is(err, NoHealthyConnectionError) {
+				return err
+			}
+			log.Log.Warn("dial error, retry on another endpoint: ", err)
+			continue
+		}
+
+		start := time.Now()
+		err = fn(client)
+		if !isEndpointError(err) {
+			if ep := j.endpointOf(client); ep != nil {
+				ep.markSuccess(time.Since(start))
+			}
+		}
+		j.releaseWithError(client, err)
+
+		if !isEndpointError(err) || ctx.Err() != nil {
+			return err
+		}
+		log.Log.Warn("endpoint error, retry on another endpoint: ", err)
+	}
+	return err
+}
+
+func (j *Jk) maxAttempts() int {
+	if j.opts.maxAttempts > 0 {
+		return j.opts.maxAttempts
+	}
+	return len(j.endpoints) + 1
+}
+
+// isEndpointError 节点不可达的错误, 节点返回的业务错误和本地错误不算.
+// 调用方的 ctx 超时也实现了 net.Error, 不算节点故障; 连接和探活超时由 dial 和 probe 自己记录
+func isEndpointError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= http.StatusInternalServerError || httpErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (j *Jk) Close() {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if j.closed {
+		return
+	}
+
+	j.closed = true
+	close(j.done)
+	j.m.Unlock()
+
+	//订阅的 goroutine 可能还要用连接池, 不能拿着锁等它退出
+	j.heads.stop()
+
+	j.m.Lock()
+	for _, ep := range j.endpoints {
+		//关闭通道，不让写入了
+		close(ep.idle)
+
+		//关闭通道里的资源
+		for r := range ep.idle {
+			j.discard(r)
+		}
+	}
+}
+
+// pick 按负载策略选一个不在冷却中的节点, 都在冷却时选最早恢复的
+func (j *Jk) pick() *endpoint {
+	now := time.Now()
+
+	candidates := make([]*endpoint, 0, len(j.endpoints))
+	for _, ep := range j.endpoints {
+		if ep.available(now) {
+			candidates = append(candidates, ep)
+		}
+	}
+
+	if len(candidates) == 0 {
+		best := j.endpoints[0]
+		for _, ep := range j.endpoints[1:] {
+			if ep.cooldownEnd().Before(best.cooldownEnd()) {
+				best = ep
+			}
+		}
+		return best
+	}
+
+	if j.opts.balancer == LowestLatency {
+		best := candidates[0]
+		for _, ep := range candidates[1:] {
+			if ep.currentLatency() < best.currentLatency() {
+				best = ep
+			}
+		}
+		return best
+	}
+
+	n := atomic.AddUint64(&j.next, 1)
+	return candidates[n%uint64(len(candidates))]
+}
+
+func (j *Jk) endpointOf(r *ethclient.Client) *endpoint {
+	pc, ok := j.owners.Load(r)
+	if !ok {
+		return nil
+	}
+	return pc.(*pooledConn).ep
+}
+
+func (j *Jk) rpcOf(r *ethclient.Client) *rpc.Client {
+	pc, ok := j.owners.Load(r)
+	if !ok {
+		return nil
+	}
+	return pc.(*pooledConn).rpc
+}
+
+func (j *Jk) markFailure(ep *endpoint, err error) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.failures++
+	backoff := j.opts.cooldown << uint(ep.failures-1)
+	if backoff > j.opts.maxCooldown || backoff <= 0 {
+		backoff = j.opts.maxCooldown
+	}
+	ep.cooldownUntil = time.Now().Add(backoff)
+
+	log.Log.Warn("endpoint ", ep.url, " cool down ", backoff, " failures: ", ep.failures, " err: ", err)
+}
+
+func (ep *endpoint) markSuccess(latency time.Duration) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.failures = 0
+	ep.cooldownUntil = time.Time{}
+	if ep.latency == 0 {
+		ep.latency = latency
+	} else {
+		ep.latency = (ep.latency*4 + latency) / 5
+	}
+}
+
+func (ep *endpoint) available(now time.Time) bool {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return !now.Before(ep.cooldownUntil)
+}
+
+func (ep *endpoint) cooldownEnd() time.Time {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return ep.cooldownUntil
+}
+
+func (ep *endpoint) currentLatency() time.Duration {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return ep.latency
+}
+
+func (j *Jk) dial(ctx context.Context, ep *endpoint) (*ethclient.Client, error) {
+	timeoutC, cancel := context.WithTimeout(ctx, j.opts.dialTimeout)
+	defer cancel()
+
+	connect, err := rpc.DialContext(timeoutC, ep.url)
+	if err != nil {
+		log.Log.Error("connect eth ", ep.url, " error", err)
+		//调用方取消或超时不是节点的问题
+		if ctx.Err() == nil {
+			j.markFailure(ep, err)
+		}
+		return nil, err
+	}
+
+	ec := ethclient.NewClient(connect)
+	j.owners.Store(ec, &pooledConn{ep: ep, rpc: connect})
+	return ec, nil
+}
+
+// probe http 连接是懒加载的, 发一个请求确认节点可用
+func (j *Jk) probe(ctx context.Context, client *ethclient.Client) error {
+	timeoutC, cancel := context.WithTimeout(ctx, j.opts.healthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.BlockNumber(timeoutC)
+
+	if ep := j.endpointOf(client); ep != nil {
+		if err != nil {
+			j.markFailure(ep, err)
+		} else {
+			ep.markSuccess(time.Since(start))
+		}
+	}
+	return err
+}
+
+func (j *Jk) healthCheck() {
+	ticker := time.NewTicker(j.opts.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.probeIdle()
+		case <-j.done:
+			return
+		}
+	}
+}
+
+// probeIdle 检查池子里空闲的连接, 剔除失效的
+func (j *Jk) probeIdle() {
+	for _, ep := range j.endpoints {
+		n := len(ep.idle)
+		for i := 0; i < n; i++ {
+			var r *ethclient.Client
+			select {
+			case c, ok := <-ep.idle:
+				if !ok {
+					return
+				}
+				r = c
+			default:
+			}
+			if r == nil {
+				break
+			}
+
+			err := j.probe(context.Background(), r)
+			if err != nil {
+				log.Log.Warn("evict dead connection: ", ep.url, " ", err)
+				j.discard(r)
+				continue
+			}
+
+			j.Release(r)
+		}
+	}
+}
+
+// evictIdle 关掉一个其他节点的空闲连接
+func (j *Jk) evictIdle(keep *endpoint) {
+	for _, ep := range j.endpoints {
+		if ep == keep {
+			continue
+		}
+		select {
+		case r, ok := <-ep.idle:
+			if ok {
+				j.discard(r)
+				return
+			}
+		default:
+		}
+	}
+}
+
+func (j *Jk) idleCount() int {
+	n := 0
+	for _, ep := range j.endpoints {
+		n += len(ep.idle)
+	}
+	return n
+}
+
+func (j *Jk) takeSlot() bool {
+	if j.slots == nil {
+		return true
+	}
+	select {
+	case j.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (j *Jk) freeSlot() {
+	if j.slots == nil {
+		return
+	}
+	select {
+	case <-j.slots:
+	default:
+	}
+}
+
+func (j *Jk) discard(r *ethclient.Client) {
+	r.Close()
+	j.owners.Delete(r)
+	j.freeSlot()
+}
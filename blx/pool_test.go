@@ -0,0 +1,232 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/sirupsen/logrus"
+)
+
+func TestNewJkWithOptionsNoHealthyConnection(t *testing.T) {
+	node := newTestNode(t)
+	dead := newTestNode(t)
+	dead.setDown(true)
+
+	_, err := NewJkWithOptions(context.Background(), WithEndpoint(dead.url), WithDialTimeout(time.Second), WithLogLevel(logrus.ErrorLevel))
+	if err != NoHealthyConnectionError {
+		t.Fatal("expect NoHealthyConnectionError, got ", err)
+	}
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	id, err := jk.ChainId(context.Background())
+	if err != nil || id.Int64() != 1337 {
+		t.Error("chain id should be read from node, got ", id, err)
+	}
+}
+
+func TestJkMaxOpen(t *testing.T) {
+	node := newTestNode(t)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithPoolSize(1), WithMaxOpen(1), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	client, err := jk.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err = jk.AcquireContext(ctx); err != context.DeadlineExceeded {
+		t.Fatal("acquire beyond max open should wait, got ", err)
+	}
+
+	jk.Release(client)
+	again, err := jk.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jk.Release(again)
+}
+
+func TestJkHealthCheckEvictsDeadConnections(t *testing.T) {
+	node := newTestNode(t)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithPoolSize(2), WithHealthCheck(0, time.Second), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	jk.probeIdle()
+	if jk.idleCount() != 2 {
+		t.Fatal("healthy connections should stay, got ", jk.idleCount())
+	}
+
+	node.setDown(true)
+	jk.probeIdle()
+	if jk.idleCount() != 0 {
+		t.Fatal("dead connections should be evicted, got ", jk.idleCount())
+	}
+}
+
+func TestJkFailover(t *testing.T) {
+	a := newTestNode(t)
+	b := newTestNode(t)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoints(a.url, b.url), WithPoolSize(2), WithHealthCheck(0, time.Second), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	a.setDown(true)
+	for i := 0; i < 4; i++ {
+		balance, err := jk.GetBalanceOf(context.Background(), "0xffa27ebf4278105425b6D211F3557e2D3433F9A7")
+		if err != nil {
+			t.Fatal("call should fail over to the healthy endpoint: ", err)
+		}
+		if balance != 1 {
+			t.Fatal("unexpected balance ", balance)
+		}
+	}
+
+	if jk.endpoints[0].available(time.Now()) {
+		t.Error("failing endpoint should be cooling down")
+	}
+	if jk.pick() != jk.endpoints[1] {
+		t.Error("pick should skip the cooling endpoint")
+	}
+}
+
+func TestJkCallerDeadlineIsNotEndpointFailure(t *testing.T) {
+	a := newTestNode(t)
+	b := newTestNode(t)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoints(a.url, b.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	calls := 0
+	err = jk.withClient(context.Background(), func(client *ethclient.Client) error {
+		calls++
+		_, err := client.BlockNumber(ctx)
+		return err
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || calls != 1 {
+		t.Fatalf("err %v after %d calls, want context.DeadlineExceeded without retry", err, calls)
+	}
+	for _, ep := range jk.endpoints {
+		if !ep.available(time.Now()) {
+			t.Errorf("endpoint %s cooling down after a caller deadline", ep.url)
+		}
+	}
+}
+
+func TestJkDialFailover(t *testing.T) {
+	node := newTestNode(t)
+	//ws 地址在拨号时就会失败
+	dead := "ws://127.0.0.1:1"
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoints(node.url, dead), WithPoolSize(1), WithHealthCheck(0, time.Second), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	//调用方取消的拨号不让节点冷却
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := jk.dial(ctx, jk.endpoints[1]); err == nil {
+		t.Fatal("dial with a canceled ctx succeeded")
+	}
+	if !jk.endpoints[1].available(time.Now()) {
+		t.Fatal("endpoint cooling down after a canceled dial")
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := jk.GetBalanceOf(context.Background(), "0xffa27ebf4278105425b6D211F3557e2D3433F9A7"); err != nil {
+			t.Fatal("call should fail over when dialing an endpoint fails: ", err)
+		}
+	}
+	if jk.endpoints[1].available(time.Now()) {
+		t.Error("endpoint failing to dial should be cooling down")
+	}
+}
+
+func TestJkRebroadcastAlreadyKnown(t *testing.T) {
+	a := newTestNode(t)
+	b := newTestNodeOn(t, a.eth)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoints(a.url, b.url), WithLogLevel(logrus.ErrorLevel), WithLegacyTx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	//第一个节点收下交易后断开, 重发时另一个节点说已经有这笔交易
+	key, _ := crypto.GenerateKey()
+	a.eth.mu.Lock()
+	a.eth.dropSends = 1
+	a.eth.mu.Unlock()
+
+	hash, err := jk.SendAsyncBig(context.Background(), NewKeySigner(key), common.HexToAddress("0xd1").Hex(), big.NewInt(1), 0)
+	if err != nil {
+		t.Fatal("rebroadcast of an accepted tx must succeed: ", err)
+	}
+	a.eth.mu.Lock()
+	defer a.eth.mu.Unlock()
+	if len(a.eth.sent) != 1 || a.eth.sent[0].Hash().Hex() != hash {
+		t.Fatalf("hash %s, sent %d transactions", hash, len(a.eth.sent))
+	}
+}
+
+func TestNewJkWithNetworkWithoutHealthCheck(t *testing.T) {
+	node := newTestNode(t)
+
+	//旧接口的调用方不 Close, 不能留下健康检查的 goroutine
+	jk := NewJkWithNetwork(1, &NetworkConfig{Name: "legacy", Endpoints: []string{node.url}, ChainId: 1337, Decimals: 18}, logrus.ErrorLevel)
+	defer jk.Close()
+	if jk.opts.healthInterval != 0 {
+		t.Fatalf("legacy pool health checks every %v", jk.opts.healthInterval)
+	}
+}
+
+func TestJkCloseStopsHeadFeed(t *testing.T) {
+	node := newTestNode(t)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithWsEndpoints(node.wsURL), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jk.Close()
+
+	//Close 之后的等待不再启动订阅
+	if err := jk.heads.wait(context.Background(), nil, 10*time.Millisecond, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := node.eth.subscribers(); n != 0 {
+		t.Fatalf("%d head subscriptions after Close", n)
+	}
+}
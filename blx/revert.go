@@ -0,0 +1,203 @@
+package blx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+var panicSelector = crypto.Keccak256([]byte("Panic(uint256)"))[:4]
+var errorSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+
+// panicReasons solidity 0.8 Panic(uint256) 的错误码
+var panicReasons = map[uint64]string{
+	0x00: "generic compiler panic",
+	0x01: "assert failed",
+	0x11: "arithmetic overflow or underflow",
+	0x12: "division or modulo by zero",
+	0x21: "invalid enum value",
+	0x22: "invalid storage byte array encoding",
+	0x31: "pop on empty array",
+	0x32: "array index out of bounds",
+	0x41: "out of memory",
+	0x51: "call to zero-initialized function",
+}
+
+// RevertError is a reverted call or mined transaction with its decoded revert data. Only one of
+// Reason, PanicCode and ErrorName is set, none when the data could not be decoded. TxHash is
+// empty for calls and gas estimations.
+type RevertError struct {
+	TxHash common.Hash
+	Block  uint64
+	Data   []byte
+
+	// Reason is the message of require and revert("...").
+	Reason string
+	// PanicCode is set for Panic(uint256), asserts and arithmetic errors.
+	PanicCode *big.Int
+	// ErrorName and ErrorArgs are a custom error found in the error ABIs.
+	ErrorName string
+	ErrorArgs []interface{}
+
+	cause error
+}
+
+func (e *RevertError) Error() string {
+	var msg string
+	switch {
+	case e.Reason != "":
+		msg = "execution reverted: " + e.Reason
+	case e.PanicCode != nil:
+		msg = fmt.Sprintf("execution reverted: panic 0x%x (%s)", e.PanicCode, e.PanicReason())
+	case e.ErrorName != "":
+		args := make([]string, len(e.ErrorArgs))
+		for i, arg := range e.ErrorArgs {
+			args[i] = fmt.Sprint(arg)
+		}
+		msg = fmt.Sprintf("execution reverted: %s(%s)", e.ErrorName, strings.Join(args, ", "))
+	case len(e.Data) > 0:
+		msg = "execution reverted: unknown data " + hexutil.Encode(e.Data)
+	default:
+		msg = "execution reverted"
+	}
+
+	if e.TxHash != (common.Hash{}) {
+		return fmt.Sprintf("transaction %s in block %d %s", e.TxHash.Hex(), e.Block, msg)
+	}
+	return msg
+}
+
+// Unwrap returns SendTransactionFailedError for mined transactions and the node error otherwise,
+// so errors.Is(err, SendTransactionFailedError) still holds for the sync send APIs.
+func (e *RevertError) Unwrap() error {
+	if e.TxHash != (common.Hash{}) {
+		return SendTransactionFailedError
+	}
+	return e.cause
+}
+
+func (e *RevertError) Code() ErrorCode {
+	return CodeExecutionReverted
+}
+
+// PanicReason describes PanicCode.
+func (e *RevertError) PanicReason() string {
+	if e.PanicCode == nil {
+		return ""
+	}
+	if e.PanicCode.IsUint64() {
+		if reason, ok := panicReasons[e.PanicCode.Uint64()]; ok {
+			return reason
+		}
+	}
+	return "unknown panic"
+}
+
+// DecodeRevert decodes revert data as Error(string), Panic(uint256) or a custom error of errorABIs.
+func DecodeRevert(data []byte, errorABIs ...abi.ABI) *RevertError {
+	e := &RevertError{Data: data}
+	if len(data) < 4 {
+		return e
+	}
+
+	switch {
+	case bytes.Equal(data[:4], errorSelector):
+		reason, err := abi.UnpackRevert(data)
+		if err == nil {
+			e.Reason = reason
+		}
+	case bytes.Equal(data[:4], panicSelector):
+		if len(data) == 4+32 {
+			e.PanicCode = new(big.Int).SetBytes(data[4:])
+		}
+	default:
+		for _, parsed := range errorABIs {
+			for _, abiErr := range parsed.Errors {
+				if !bytes.Equal(data[:4], abiErr.ID[:4]) {
+					continue
+				}
+				args, err := abiErr.Inputs.Unpack(data[4:])
+				if err != nil {
+					continue
+				}
+				e.ErrorName, e.ErrorArgs = abiErr.Name, args
+				return e
+			}
+		}
+	}
+	return e
+}
+
+// asRevert 节点返回 execution reverted 时转成 RevertError, 其他错误原样返回. extra 是调用的合约自己的 ABI
+func (j *Jk) asRevert(err error, extra ...abi.ABI) error {
+	if err == nil {
+		return nil
+	}
+
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if s, ok := dataErr.ErrorData().(string); ok {
+			if data, decodeErr := hexutil.Decode(s); decodeErr == nil {
+				e := DecodeRevert(data, append(append([]abi.ABI(nil), extra...), j.errorABIs...)...)
+				e.cause = err
+				return e
+			}
+		}
+	}
+	if strings.Contains(err.Error(), "execution reverted") {
+		return &RevertError{cause: err}
+	}
+	return err
+}
+
+// RevertReason replays a mined failed transaction with eth_call on the state of its block and
+// decodes why it reverted. The state is the one after the block, a revert that depended on
+// transactions in the same block may come back without reason. errorABIs are searched for
+// custom errors before the ones of WithErrorABI.
+func (j *Jk) RevertReason(ctx context.Context, hash common.Hash, errorABIs ...abi.ABI) (*RevertError, error) {
+	var revert *RevertError
+	err := j.withClient(ctx, func(client *ethclient.Client) error {
+		receipt, err := client.TransactionReceipt(ctx, hash)
+		if err != nil {
+			return err
+		}
+		if receipt.Status != types.ReceiptStatusFailed {
+			return fmt.Errorf("transaction %s did not fail", hash.Hex())
+		}
+		tx, _, err := client.TransactionByHash(ctx, hash)
+		if err != nil {
+			return err
+		}
+		from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+		if err != nil {
+			return err
+		}
+
+		//不带 gas 价格, 避免按当时的余额检查交易费
+		msg := ethereum.CallMsg{From: from, To: tx.To(), Gas: tx.Gas(), Value: tx.Value(), Data: tx.Data()}
+		_, err = client.CallContract(ctx, msg, receipt.BlockNumber)
+
+		revert = &RevertError{}
+		if e, ok := j.asRevert(err, errorABIs...).(*RevertError); ok {
+			revert = e
+		} else if err != nil {
+			log.Log.Warn("replay ", hash.Hex(), " err: ", err)
+		}
+		revert.TxHash, revert.Block, revert.cause = hash, receipt.BlockNumber.Uint64(), nil
+		return nil
+	})
+	return revert, err
+}
@@ -0,0 +1,109 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/sirupsen/logrus"
+)
+
+const testErrorABI = `[{"type":"error","name":"InsufficientBalance","inputs":[{"name":"available","type":"uint256"},{"name":"required","type":"uint256"}]}]`
+
+func revertData(t *testing.T, parsed abi.ABI, name string, args ...interface{}) []byte {
+	abiErr := parsed.Errors[name]
+	packed, err := abiErr.Inputs.Pack(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(append([]byte{}, abiErr.ID[:4]...), packed...)
+}
+
+func reasonData(t *testing.T, reason string) []byte {
+	typ, _ := abi.NewType("string", "", nil)
+	packed, err := abi.Arguments{{Type: typ}}.Pack(reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(append([]byte{}, errorSelector...), packed...)
+}
+
+func TestDecodeRevert(t *testing.T) {
+	parsed, err := abi.JSON(strings.NewReader(testErrorABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := DecodeRevert(reasonData(t, "not owner"))
+	if e.Reason != "not owner" || e.Error() != "execution reverted: not owner" {
+		t.Fatalf("unexpected reason %q", e.Error())
+	}
+
+	e = DecodeRevert(append(append([]byte{}, panicSelector...), common.LeftPadBytes([]byte{0x11}, 32)...))
+	if e.PanicCode == nil || e.PanicCode.Int64() != 0x11 || e.PanicReason() != "arithmetic overflow or underflow" {
+		t.Fatalf("unexpected panic %v", e)
+	}
+
+	data := revertData(t, parsed, "InsufficientBalance", big.NewInt(1), big.NewInt(5))
+	e = DecodeRevert(data, parsed)
+	if e.ErrorName != "InsufficientBalance" || len(e.ErrorArgs) != 2 || e.ErrorArgs[1].(*big.Int).Int64() != 5 {
+		t.Fatalf("unexpected custom error %v", e)
+	}
+	if e.Error() != "execution reverted: InsufficientBalance(1, 5)" {
+		t.Fatalf("unexpected message %q", e.Error())
+	}
+
+	if e = DecodeRevert(data); e.ErrorName != "" || !strings.Contains(e.Error(), "unknown data") {
+		t.Fatalf("custom error decoded without abi: %v", e)
+	}
+}
+
+func TestRevertReason(t *testing.T) {
+	node := newTestNode(t)
+	contract := common.HexToAddress("0xc1")
+	node.eth.setRevert(contract, reasonData(t, "paused"))
+
+	tx := newTestTx(t, 0, contract, big.NewInt(0))
+	node.eth.addTx(101, tx)
+	node.eth.buildChain(101, 101, 0)
+	node.eth.setReceipt(&types.Receipt{Status: types.ReceiptStatusFailed, TxHash: tx.Hash(), GasUsed: 21000, BlockNumber: big.NewInt(101)})
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel), WithErrorABI(testErrorABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	err = jk.waitReceipt(context.Background(), tx.Hash())
+	var revert *RevertError
+	if !errors.As(err, &revert) {
+		t.Fatalf("err %v, want RevertError", err)
+	}
+	if revert.Reason != "paused" || revert.TxHash != tx.Hash() || revert.Block != 101 {
+		t.Fatalf("unexpected revert %+v", revert)
+	}
+	if !errors.Is(err, SendTransactionFailedError) {
+		t.Fatal("mined revert does not wrap SendTransactionFailedError")
+	}
+
+	//估算 gas 失败时解析自定义错误
+	parsed, _ := abi.JSON(strings.NewReader(testErrorABI))
+	node.eth.setRevert(contract, revertData(t, parsed, "InsufficientBalance", big.NewInt(0), big.NewInt(9)))
+	err = jk.withClient(context.Background(), func(client *ethclient.Client) error {
+		_, err := client.EstimateGas(context.Background(), ethereum.CallMsg{To: &contract})
+		return jk.asRevert(err)
+	})
+	if !errors.As(err, &revert) || revert.ErrorName != "InsufficientBalance" || revert.TxHash != (common.Hash{}) {
+		t.Fatalf("unexpected estimate err %v", err)
+	}
+	if errors.Is(err, SendTransactionFailedError) {
+		t.Fatal("estimate revert wraps SendTransactionFailedError")
+	}
+}
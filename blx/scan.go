@@ -0,0 +1,524 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+var ReorgTooDeepError = newCodedError(CodeReorgTooDeep)
+var ParentHashMismatchError = newCodedError(CodeParentHashMismatch)
+var ScannerRunningError = newCodedError(CodeScannerRunning)
+var NoDeadLetterStoreError = newCodedError(CodeNoDeadLetterStore)
+
+var errScanStopped = errors.New("scanner stopped")
+
+// ScannedBlock is a handled block kept in the checkpoint to detect reorgs.
+type ScannedBlock struct {
+	Number uint64      `json:"number"`
+	Hash   common.Hash `json:"hash"`
+}
+
+type ScanOption func(*scanOptions)
+
+type scanOptions struct {
+	confirmations  *uint64
+	reorgDepth     int
+	onRollback     func(from, to uint64) error
+	pollInterval   time.Duration
+	batchSize      uint64
+	throughput     float64
+	endNumber      *uint64
+	concurrency    int
+	fetchBatch     uint64
+	receipts       bool
+	eventABIs      []string
+	deadLetters    DeadLetterStore
+	replayAttempts int
+	replayBackoff  time.Duration
+}
+
+func newScanOptions(opts []ScanOption) *scanOptions {
+	o := &scanOptions{reorgDepth: 128, pollInterval: 2 * time.Second, batchSize: 100, concurrency: 4, fetchBatch: 10, replayAttempts: 5, replayBackoff: time.Minute}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithConfirmations keeps the scanner n blocks behind the head, the default comes from the network.
+func WithConfirmations(n uint64) ScanOption {
+	return func(o *scanOptions) {
+		o.confirmations = &n
+	}
+}
+
+// WithReorgDepth sets how many handled block hashes are kept, a reorg deeper than that stops the scanner.
+func WithReorgDepth(n int) ScanOption {
+	return func(o *scanOptions) {
+		if n > 0 {
+			o.reorgDepth = n
+		}
+	}
+}
+
+// OnRollback is called with the orphaned heights from..to, both handled before, ahead of
+// scanning the canonical blocks again. An error keeps the checkpoint and retries next round.
+func OnRollback(fn func(from, to uint64) error) ScanOption {
+	return func(o *scanOptions) {
+		o.onRollback = fn
+	}
+}
+
+// WithPollInterval sets how long the scanner waits for new blocks once it caught up. With a
+// websocket endpoint it is woken by new heads and only polls while the subscription is down.
+func WithPollInterval(d time.Duration) ScanOption {
+	return func(o *scanOptions) {
+		if d > 0 {
+			o.pollInterval = d
+		}
+	}
+}
+
+// WithBatchSize sets how many blocks are handled per round, the checkpoint is saved after every block.
+func WithBatchSize(n uint64) ScanOption {
+	return func(o *scanOptions) {
+		if n > 0 {
+			o.batchSize = n
+		}
+	}
+}
+
+// WithThroughput limits the scanner to blocksPerSecond, 0 means no limit.
+func WithThroughput(blocksPerSecond float64) ScanOption {
+	return func(o *scanOptions) {
+		o.throughput = blocksPerSecond
+	}
+}
+
+// WithEndNumber stops the scanner after block n is handled, n included.
+func WithEndNumber(n uint64) ScanOption {
+	return func(o *scanOptions) {
+		o.endNumber = &n
+	}
+}
+
+// WithConcurrency sets how many block requests are in flight while catching up, blocks are still
+// handled in height order.
+func WithConcurrency(n int) ScanOption {
+	return func(o *scanOptions) {
+		if n > 0 {
+			o.concurrency = n
+		}
+	}
+}
+
+// WithFetchBatch sets how many blocks one JSON-RPC batch asks for.
+func WithFetchBatch(n uint64) ScanOption {
+	return func(o *scanOptions) {
+		if n > 0 {
+			o.fetchBatch = n
+		}
+	}
+}
+
+// WithReceipts fetches the receipt of every transaction, so the handler of NewReceiptScanner can
+// see the status and the decoded logs. Without it receipt and logs are nil.
+func WithReceipts() ScanOption {
+	return func(o *scanOptions) {
+		o.receipts = true
+	}
+}
+
+// WithEventABI adds the events of abiJSON to the decoded logs, ERC-20, ERC-721 and ERC-1155
+// events are always decoded.
+func WithEventABI(abiJSON string) ScanOption {
+	return func(o *scanOptions) {
+		o.eventABIs = append(o.eventABIs, abiJSON)
+	}
+}
+
+// WithDeadLetters records the transactions the handler fails on in store, for ReplayFailed.
+// Without it they are appended to ./errtx.info.
+func WithDeadLetters(store DeadLetterStore) ScanOption {
+	return func(o *scanOptions) {
+		o.deadLetters = store
+	}
+}
+
+// WithReplayLimit sets how many times a transaction may fail, the scan included, before
+// ReplayFailed gives up on it, and the wait before the first replay, doubled after every failure.
+func WithReplayLimit(attempts int, backoff time.Duration) ScanOption {
+	return func(o *scanOptions) {
+		if attempts > 0 {
+			o.replayAttempts = attempts
+		}
+		if backoff >= 0 {
+			o.replayBackoff = backoff
+		}
+	}
+}
+
+func (o *scanOptions) confirmationsOf(net *NetworkConfig) uint64 {
+	if o.confirmations != nil {
+		return *o.confirmations
+	}
+	return net.Confirmations
+}
+
+// ReceiptHandler gets a transaction with its receipt and the logs decoded from it.
+type ReceiptHandler func(tx *types.Transaction, block *types.Block, receipt *types.Receipt, logs []DecodedLog) error
+
+// Scanner hands every transaction of the confirmed blocks from a start height on to a handler,
+// keeping its progress in a CheckpointStore.
+type Scanner struct {
+	jk        *Jk
+	store     CheckpointStore
+	namespace string
+	start     uint64
+	handle    ReceiptHandler
+	opts      *scanOptions
+	decoder   *logDecoder
+
+	mu      sync.Mutex
+	running bool
+	stop    chan struct{}
+	head    uint64
+}
+
+// NewScanner scans from startNumber, included, unless the checkpoint in namespace is further.
+func (j *Jk) NewScanner(store CheckpointStore, namespace string, startNumber uint64, handle func(tx *types.Transaction, block *types.Block) error, opts ...ScanOption) (*Scanner, error) {
+	if handle == nil {
+		return nil, errors.New("handle can not be nil")
+	}
+	return j.NewReceiptScanner(store, namespace, startNumber, func(tx *types.Transaction, block *types.Block, receipt *types.Receipt, logs []DecodedLog) error {
+		return handle(tx, block)
+	}, opts...)
+}
+
+// NewReceiptScanner is NewScanner with a ReceiptHandler, use it together with WithReceipts.
+func (j *Jk) NewReceiptScanner(store CheckpointStore, namespace string, startNumber uint64, handle ReceiptHandler, opts ...ScanOption) (*Scanner, error) {
+	if store == nil {
+		return nil, errors.New("checkpoint store can not be nil")
+	}
+	if handle == nil {
+		return nil, errors.New("handle can not be nil")
+	}
+	if err := checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+
+	o := newScanOptions(opts)
+	decoder, err := newLogDecoder(append([]string{AbiErc20, AbiErc721, AbiErc1155}, o.eventABIs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scanner{
+		jk:        j,
+		store:     store,
+		namespace: namespace,
+		start:     startNumber,
+		handle:    handle,
+		opts:      o,
+		decoder:   decoder,
+	}, nil
+}
+
+// Start scans until ctx is done, Stop is called or the end number is handled. Failed rounds are
+// logged and retried after the poll interval.
+func (s *Scanner) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return ScannerRunningError
+	}
+	s.running = true
+	stop := make(chan struct{})
+	s.stop = stop
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.stop = nil
+		s.mu.Unlock()
+	}()
+
+	for {
+		more, err := s.scan(ctx, stop)
+		if err == errScanStopped {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Log.Error("scan ", s.namespace, " err: ", err, " retry after ", s.opts.pollInterval)
+		} else if s.finished() {
+			log.Log.Info("scan ", s.namespace, " reached end number ", *s.opts.endNumber)
+			return nil
+		}
+
+		if err == nil && more {
+			continue
+		}
+		if err = s.jk.heads.wait(ctx, stop, s.opts.pollInterval, time.Time{}); err != nil {
+			if err == errScanStopped {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Stop makes a running Start return after the block in progress.
+func (s *Scanner) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// RunOnce handles one batch of confirmed blocks after the checkpoint.
+func (s *Scanner) RunOnce(ctx context.Context) error {
+	_, err := s.scan(ctx, nil)
+	return err
+}
+
+// Head is the chain head seen by the last round, before the confirmation lag.
+func (s *Scanner) Head() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.head
+}
+
+func (s *Scanner) finished() bool {
+	if s.opts.endNumber == nil {
+		return false
+	}
+	cp, err := s.store.Load(s.namespace)
+	return err == nil && cp != nil && cp.Height >= *s.opts.endNumber
+}
+
+func (s *Scanner) pause(ctx context.Context, stop chan struct{}, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-stop:
+		return errScanStopped
+	case <-timer.C:
+		return nil
+	}
+}
+
+// scan 处理检查点之后最多 batchSize 个已确认区块, more 表示还没追上
+func (s *Scanner) scan(ctx context.Context, stop chan struct{}) (more bool, err error) {
+	j := s.jk
+
+	client, err := j.AcquireContext(ctx)
+	if err != nil {
+		log.Log.Error("acquire client: ", err)
+		return false, err
+	}
+	defer func() { j.releaseWithError(client, err) }()
+
+	highestNumber, err := client.BlockNumber(ctx)
+	if err != nil {
+		log.Log.Error("get latest block number: ", err)
+		return false, err
+	}
+	s.mu.Lock()
+	s.head = highestNumber
+	s.mu.Unlock()
+
+	confirmations := s.opts.confirmationsOf(j.net)
+	if highestNumber < confirmations {
+		log.Log.Debug("highestNumber: ", highestNumber, " not enough confirmations yet")
+		return false, nil
+	}
+	highestNumber -= confirmations
+	if s.opts.endNumber != nil && *s.opts.endNumber < highestNumber {
+		highestNumber = *s.opts.endNumber
+	}
+
+	cp, err := s.store.Load(s.namespace)
+	if err != nil {
+		log.Log.Error("load checkpoint ", s.namespace, ": ", err)
+		return false, err
+	}
+
+	next := s.start
+	var recent []ScannedBlock
+	if cp != nil {
+		recent = cp.Recent
+		if cp.Height+1 > next {
+			next = cp.Height + 1
+		}
+	}
+
+	if next > highestNumber {
+		log.Log.Debug("next: ", next, " highestNumber: ", highestNumber, " no new block")
+		return false, nil
+	}
+
+	to := highestNumber
+	if to-next+1 > s.opts.batchSize {
+		to = next + s.opts.batchSize - 1
+	}
+	log.Log.Debug("scan ", s.namespace, " from ", next, " to ", to, " highestNumber: ", highestNumber)
+
+	var gap time.Duration
+	if s.opts.throughput > 0 {
+		gap = time.Duration(float64(time.Second) / s.opts.throughput)
+	}
+
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chunks := s.fetchBlocks(fetchCtx, client, next, to)
+
+	var pending []*types.Block
+	var pendingHashes []common.Hash
+	var pendingReceipts [][]*types.Receipt
+	for height := next; height <= to; height++ {
+		started := time.Now()
+
+		if len(pending) == 0 {
+			chunk, ok := <-chunks
+			if !ok {
+				return false, ctx.Err()
+			}
+			if chunk.err != nil {
+				err = chunk.err
+				log.Log.Error("get block height: ", height, " happened, it may lost height, stop now. ", " err info: ", err)
+				return false, err
+			}
+			pending, pendingHashes, pendingReceipts = chunk.blocks, chunk.hashes, chunk.receipts
+		}
+		block, hash := pending[0], pendingHashes[0]
+		pending, pendingHashes = pending[1:], pendingHashes[1:]
+		var receipts []*types.Receipt
+		if pendingReceipts != nil {
+			receipts = pendingReceipts[0]
+			pendingReceipts = pendingReceipts[1:]
+		}
+
+		log.Log.Debug("==============handle start height: ", height, "================================")
+
+		if n := len(recent); n > 0 && recent[n-1].Number == height-1 && block.ParentHash() != recent[n-1].Hash {
+			log.Log.Warn("block ", height, " parent ", block.ParentHash().Hex(), " != handled ", recent[n-1].Hash.Hex(), ", reorg")
+			err = s.rollback(ctx, client, recent, height)
+			if err != nil {
+				return false, err
+			}
+			//从分叉点之后重新扫主链
+			return true, nil
+		}
+
+		for i, tx := range block.Transactions() {
+			log.Log.Debug("notify handle tx for business", " txHash: ", tx.Hash(), " blockNumber: ", block.Number())
+
+			var receipt *types.Receipt
+			var logs []DecodedLog
+			if receipts != nil {
+				receipt = receipts[i]
+				logs = s.decoder.decode(receipt.Logs)
+			}
+
+			if err := s.handle(tx, block, receipt, logs); err != nil {
+				log.Log.Error("handle tx: ", tx.Hash(), " err happened ", "record block and to next")
+
+				//记录不下来就不存进度, 下一轮重扫这个区块
+				if err := s.deadLetter(tx, block, err); err != nil {
+					return false, err
+				}
+			}
+		}
+
+		recent = rememberBlock(recent, ScannedBlock{Number: height, Hash: hash}, s.opts.reorgDepth)
+		err = s.store.Save(s.namespace, &Checkpoint{Height: height, Recent: recent})
+		if err != nil {
+			log.Log.Error("save checkpoint ", s.namespace, ": ", err)
+			return false, err
+		}
+
+		log.Log.Debug("==============handle end height: ", height, "================================")
+
+		if height < to {
+			if err = s.pause(ctx, stop, gap-time.Since(started)); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return to < highestNumber, nil
+}
+
+// rollback 找到分叉点, 通知业务回滚后把检查点退回分叉点
+func (s *Scanner) rollback(ctx context.Context, client *ethclient.Client, recent []ScannedBlock, height uint64) error {
+	fork, err := findForkPoint(ctx, s.jk.rpcOf(client), client, recent)
+	if err != nil {
+		log.Log.Error("find fork point from height: ", height, " err: ", err)
+		return err
+	}
+	//上一个区块仍在主链上, 回滚什么也不会改变, 是节点数据不一致, 报错等下一轮重试
+	if fork == len(recent)-1 {
+		return ParentHashMismatchError.With("height", height).With("handled", recent[fork].Hash.Hex())
+	}
+
+	ancestor := recent[fork].Number
+	if s.opts.onRollback != nil {
+		err = s.opts.onRollback(ancestor+1, height-1)
+		if err != nil {
+			log.Log.Error("rollback ", ancestor+1, " - ", height-1, " err: ", err)
+			return err
+		}
+	}
+
+	recent = append([]ScannedBlock(nil), recent[:fork+1]...)
+	err = s.store.Save(s.namespace, &Checkpoint{Height: ancestor, Recent: recent})
+	if err != nil {
+		log.Log.Error("save checkpoint ", s.namespace, ": ", err)
+		return err
+	}
+
+	log.Log.Warn("rolled back ", s.namespace, " to height: ", ancestor)
+	return nil
+}
+
+// rememberBlock 记录处理过的区块, 只保留最近 depth 个
+func rememberBlock(recent []ScannedBlock, block ScannedBlock, depth int) []ScannedBlock {
+	recent = append(recent, block)
+	if len(recent) > depth {
+		recent = append([]ScannedBlock(nil), recent[len(recent)-depth:]...)
+	}
+	return recent
+}
+
+// findForkPoint 从新到旧找到第一个哈希仍在主链上的区块, 返回它在 recent 中的下标
+func findForkPoint(ctx context.Context, rc *rpc.Client, client *ethclient.Client, recent []ScannedBlock) (int, error) {
+	for i := len(recent) - 1; i >= 0; i-- {
+		hash, err := blockHashAt(ctx, rc, client, recent[i].Number)
+		if err != nil {
+			return 0, err
+		}
+		if hash == recent[i].Hash {
+			return i, nil
+		}
+		log.Log.Debug("block ", recent[i].Number, " ", recent[i].Hash.Hex(), " replaced by ", hash.Hex())
+	}
+	return 0, ReorgTooDeepError
+}
@@ -0,0 +1,342 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+func TestExecuteBlocksRollsBackOnReorg(t *testing.T) {
+	testRollsBackOnReorg(t, false)
+}
+
+// 非 geth 格式的区块哈希, 只能用节点返回的哈希比较
+func TestExecuteBlocksRollsBackOnReorgForeignHashes(t *testing.T) {
+	testRollsBackOnReorg(t, true)
+}
+
+func testRollsBackOnReorg(t *testing.T, foreignHashes bool) {
+	node := newTestNode(t)
+	node.eth.foreignHashes = foreignHashes
+	node.eth.buildChain(1, 5, 0)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	var rollbacks [][2]uint64
+	opts := []ScanOption{
+		WithConfirmations(0),
+		OnRollback(func(from, to uint64) error {
+			rollbacks = append(rollbacks, [2]uint64{from, to})
+			return nil
+		}),
+	}
+	handle := func(tx *types.Transaction, block *types.Block) error { return nil }
+	store := NewMemoryCheckpointStore()
+	mutex := sync.Mutex{}
+
+	run := func() *Checkpoint {
+		err := jk.ExecuteBlocks(&mutex, store, "reorg", 2, handle, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cp, _ := store.Load("reorg")
+		return cp
+	}
+
+	cp := run()
+	if cp.Height != 5 || cp.Recent[len(cp.Recent)-1].Hash != node.eth.hashOf(5) {
+		t.Fatalf("unexpected checkpoint %+v", cp)
+	}
+
+	//4 和 5 被替换, 链延长到 6
+	node.eth.buildChain(4, 6, 1)
+
+	cp = run()
+	if len(rollbacks) != 1 || rollbacks[0] != [2]uint64{4, 5} {
+		t.Fatalf("rollbacks %v, want [[4 5]]", rollbacks)
+	}
+	if cp.Height != 3 {
+		t.Fatalf("checkpoint %v after rollback, want 3", cp.Height)
+	}
+
+	cp = run()
+	if cp.Height != 6 {
+		t.Fatalf("checkpoint %v, want 6", cp.Height)
+	}
+	for _, b := range cp.Recent {
+		if b.Hash != node.eth.hashOf(b.Number) {
+			t.Fatalf("block %v not on canonical chain", b.Number)
+		}
+	}
+	if len(rollbacks) != 1 {
+		t.Fatalf("unexpected rollbacks %v", rollbacks)
+	}
+}
+
+func TestExecuteBlocksParentMismatchWithoutReorg(t *testing.T) {
+	node := newTestNode(t)
+	node.eth.buildChain(1, 5, 0)
+	node.eth.brokenParents = true
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	rollbacks := 0
+	opts := []ScanOption{WithConfirmations(0), OnRollback(func(from, to uint64) error {
+		rollbacks++
+		return nil
+	})}
+	handle := func(tx *types.Transaction, block *types.Block) error { return nil }
+	store := NewMemoryCheckpointStore()
+	mutex := sync.Mutex{}
+
+	//第一个区块没有可比较的父区块, 之后每个区块的父哈希都对不上, 但已处理的区块仍在主链上
+	for i := 0; i < 3; i++ {
+		err = jk.ExecuteBlocks(&mutex, store, "mismatch", 1, handle, opts...)
+		if !errors.Is(err, ParentHashMismatchError) {
+			t.Fatalf("run %d: expect ParentHashMismatchError, got %v", i, err)
+		}
+	}
+	if rollbacks != 0 {
+		t.Fatalf("%d rollbacks that change nothing", rollbacks)
+	}
+	cp, _ := store.Load("mismatch")
+	if cp.Height != 1 || cp.Recent[0].Hash != node.eth.hashOf(1) {
+		t.Fatalf("unexpected checkpoint %+v", cp)
+	}
+}
+
+func TestExecuteBlocksReorgTooDeep(t *testing.T) {
+	node := newTestNode(t)
+	node.eth.buildChain(1, 5, 0)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	opts := []ScanOption{WithConfirmations(0), WithReorgDepth(1)}
+	handle := func(tx *types.Transaction, block *types.Block) error { return nil }
+	store := NewMemoryCheckpointStore()
+	mutex := sync.Mutex{}
+
+	err = jk.ExecuteBlocks(&mutex, store, "deep", 3, handle, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node.eth.buildChain(3, 6, 1)
+	err = jk.ExecuteBlocks(&mutex, store, "deep", 3, handle, opts...)
+	if err != ReorgTooDeepError {
+		t.Fatalf("expect ReorgTooDeepError, got %v", err)
+	}
+}
+
+func TestScannerRange(t *testing.T) {
+	node := newTestNode(t)
+	node.eth.buildChain(1, 10, 0)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	store := NewMemoryCheckpointStore()
+	handle := func(tx *types.Transaction, block *types.Block) error { return nil }
+	s, err := jk.NewScanner(store, "range", 3, handle, WithConfirmations(2), WithBatchSize(2), WithPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, _ := store.Load("range")
+	if cp.Recent[0].Number != 3 || cp.Height != 4 {
+		t.Fatalf("first batch %+v, want 3..4", cp.Recent)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.Start(ctx) }()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		cp, _ = store.Load("range")
+		if cp.Height == 8 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scanner stuck at %v, want head 10 - 2 confirmations", cp.Height)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := s.Start(ctx); err != ScannerRunningError {
+		t.Fatalf("second Start returned %v", err)
+	}
+
+	s.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Start returned %v after Stop", err)
+	}
+}
+
+func TestScannerEndNumber(t *testing.T) {
+	node := newTestNode(t)
+	node.eth.buildChain(1, 10, 0)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	store := NewMemoryCheckpointStore()
+	handle := func(tx *types.Transaction, block *types.Block) error { return nil }
+	s, err := jk.NewScanner(store, "end", 1, handle, WithConfirmations(0), WithEndNumber(6), WithThroughput(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cp, _ := store.Load("end")
+	if cp.Height != 6 || cp.Recent[0].Number != 1 {
+		t.Fatalf("scanned %+v, want 1..6", cp.Recent)
+	}
+}
+
+func TestScannerFetchesConcurrentlyInOrder(t *testing.T) {
+	node := newTestNode(t)
+	var want []common.Hash
+	for n := uint64(1); n <= 30; n++ {
+		tx := newTestTx(t, n, common.HexToAddress("0x1"), big.NewInt(int64(n)))
+		node.eth.addTx(n, tx)
+		want = append(want, tx.Hash())
+	}
+	node.eth.buildChain(1, 30, 0)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	var got []common.Hash
+	handle := func(tx *types.Transaction, block *types.Block) error {
+		if tx.Value().Uint64() != block.NumberU64() {
+			t.Errorf("tx %v in block %v", tx.Value(), block.Number())
+		}
+		got = append(got, tx.Hash())
+		return nil
+	}
+	store := NewMemoryCheckpointStore()
+	s, err := jk.NewScanner(store, "fetch", 1, handle, WithConfirmations(0), WithConcurrency(3), WithFetchBatch(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("handled %v txs, want %v", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("tx %v out of order", i)
+		}
+	}
+	cp, _ := store.Load("fetch")
+	if cp.Height != 30 || cp.Recent[29].Hash != node.eth.hashOf(30) {
+		t.Fatalf("unexpected checkpoint %v", cp.Height)
+	}
+}
+
+func TestReceiptScannerDecodesLogs(t *testing.T) {
+	node := newTestNode(t)
+	token := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	from := common.HexToAddress("0x00000000000000000000000000000000000000f1")
+	to := common.HexToAddress("0x00000000000000000000000000000000000000f2")
+
+	failed := newTestTx(t, 0, token, big.NewInt(0))
+	transfer := newTestTx(t, 1, token, big.NewInt(0))
+	node.eth.addTx(1, failed)
+	node.eth.addTx(2, transfer)
+	node.eth.buildChain(1, 2, 0)
+
+	node.eth.setReceipt(&types.Receipt{Status: types.ReceiptStatusFailed, TxHash: failed.Hash(), GasUsed: 30000})
+	node.eth.setReceipt(&types.Receipt{
+		Status:  types.ReceiptStatusSuccessful,
+		TxHash:  transfer.Hash(),
+		GasUsed: 50000,
+		Logs: []*types.Log{
+			{
+				Address: token,
+				Topics: []common.Hash{
+					crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)")),
+					common.BytesToHash(from.Bytes()),
+					common.BytesToHash(to.Bytes()),
+				},
+				Data:   common.LeftPadBytes(big.NewInt(500).Bytes(), 32),
+				TxHash: transfer.Hash(),
+			},
+			{Address: token, Topics: []common.Hash{crypto.Keccak256Hash([]byte("Unknown()"))}, Data: []byte{}, TxHash: transfer.Hash()},
+		},
+	})
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	statuses := make(map[common.Hash]uint64)
+	var decoded []DecodedLog
+	handle := func(tx *types.Transaction, block *types.Block, receipt *types.Receipt, logs []DecodedLog) error {
+		statuses[tx.Hash()] = receipt.Status
+		decoded = append(decoded, logs...)
+		return nil
+	}
+	s, err := jk.NewReceiptScanner(NewMemoryCheckpointStore(), "receipts", 1, handle, WithConfirmations(0), WithReceipts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if statuses[failed.Hash()] != types.ReceiptStatusFailed || statuses[transfer.Hash()] != types.ReceiptStatusSuccessful {
+		t.Fatalf("unexpected statuses %v", statuses)
+	}
+	if len(decoded) != 1 || decoded[0].Name != "Transfer" {
+		t.Fatalf("decoded %+v, want one Transfer", decoded)
+	}
+	args := decoded[0].Args
+	if args["from"] != from || args["to"] != to || args["value"].(*big.Int).Int64() != 500 {
+		t.Fatalf("unexpected transfer args %v", args)
+	}
+}
@@ -0,0 +1,193 @@
+package blx
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// Signer signs transactions for one account, so send methods never see the raw key.
+type Signer interface {
+	Address() common.Address
+	SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+}
+
+// KeySigner keeps an ECDSA key in memory.
+type KeySigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+func NewKeySigner(key *ecdsa.PrivateKey) *KeySigner {
+	return &KeySigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+// NewHexSigner parses a hex private key, with or without 0x.
+func NewHexSigner(senderPrivate string) (*KeySigner, error) {
+	key, address, err := parsePrivateKey(senderPrivate)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySigner{key: key, address: address}, nil
+}
+
+func (s *KeySigner) Address() common.Address {
+	return s.address
+}
+
+func (s *KeySigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), s.key)
+}
+
+// NewKeystoreSigner decrypts a geth style keystore file.
+func NewKeystoreSigner(path string, passphrase string) (*KeySigner, error) {
+	keyJson, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := keystore.DecryptKey(keyJson, passphrase)
+	if err != nil {
+		log.Log.Error("decrypt keystore ", path, " err: ", err)
+		return nil, PrivateKeyError.Wrap(err)
+	}
+
+	return &KeySigner{key: key.PrivateKey, address: key.Address}, nil
+}
+
+// parsePrivateKey 解析 hex 私钥, 可以带 0x 前缀
+func parsePrivateKey(senderPrivate string) (*ecdsa.PrivateKey, common.Address, error) {
+	if strings.HasPrefix(senderPrivate, "0x") {
+		senderPrivate = senderPrivate[2:]
+	}
+
+	privateKey, err := crypto.HexToECDSA(senderPrivate)
+	if err != nil {
+		log.Log.Error(fmt.Sprintf("recover key err: %v", err))
+		return nil, common.Address{}, PrivateKeyError.Wrap(err)
+	}
+	publicKey := privateKey.Public()
+	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok {
+		log.Log.Error("cannot assert type: publicKey is not of type *ecdsa.PublicKey")
+		return nil, common.Address{}, PrivateKeyError
+	}
+
+	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
+	log.Log.Debug("Public Key: ", hexutil.Encode(publicKeyBytes))
+
+	address := crypto.PubkeyToAddress(*publicKeyECDSA)
+	log.Log.Debug("Address: ", address.Hex())
+
+	return privateKey, address, nil
+}
+
+// RemoteSigner asks a JSON-RPC signer such as clef or a custody service to sign,
+// the key never leaves that service.
+type RemoteSigner struct {
+	client  *rpc.Client
+	address common.Address
+	method  string
+	timeout time.Duration
+}
+
+// NewRemoteSigner dials url, method defaults to eth_signTransaction, clef uses account_signTransaction.
+func NewRemoteSigner(ctx context.Context, url string, address common.Address, method string) (*RemoteSigner, error) {
+	client, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	if method == "" {
+		method = "eth_signTransaction"
+	}
+
+	return &RemoteSigner{client: client, address: address, method: method, timeout: 30 * time.Second}, nil
+}
+
+func (s *RemoteSigner) Address() common.Address {
+	return s.address
+}
+
+// signTxArgs 对应 geth 的 TransactionArgs
+type signTxArgs struct {
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas,omitempty"`
+	Value                *hexutil.Big    `json:"value"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Data                 hexutil.Bytes   `json:"data"`
+	ChainId              *hexutil.Big    `json:"chainId"`
+}
+
+type signTxResult struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+func (s *RemoteSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	args := signTxArgs{
+		From:    s.address,
+		To:      tx.To(),
+		Gas:     hexutil.Uint64(tx.Gas()),
+		Value:   (*hexutil.Big)(tx.Value()),
+		Nonce:   hexutil.Uint64(tx.Nonce()),
+		Data:    tx.Data(),
+		ChainId: (*hexutil.Big)(chainID),
+	}
+	if tx.Type() == types.DynamicFeeTxType {
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	} else {
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var result signTxResult
+	err := s.client.CallContext(ctx, &result, s.method, args)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := new(types.Transaction)
+	err = signed.UnmarshalBinary(result.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	//不信任远端, 检查签名账户, 并且签名哈希要和请求的交易一致, 包括数据, 手续费, 类型和链 id
+	signer := types.LatestSignerForChainID(chainID)
+	sender, err := types.Sender(signer, signed)
+	if err != nil {
+		return nil, err
+	}
+	if sender != s.address {
+		return nil, fmt.Errorf("remote signer signed with %v, want %v", sender.Hex(), s.address.Hex())
+	}
+	if signed.Type() != tx.Type() || signer.Hash(signed) != signer.Hash(tx) {
+		return nil, errors.New("remote signer changed the transaction")
+	}
+
+	return signed, nil
+}
+
+func (s *RemoteSigner) Close() {
+	s.client.Close()
+}
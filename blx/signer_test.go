@@ -0,0 +1,155 @@
+package blx
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sirupsen/logrus"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// standInSigner 本地代替远程签名服务
+type standInSigner struct {
+	key *ecdsa.PrivateKey
+	//tamper 模拟被攻破的签名服务改写交易
+	tamper func(args *signTxArgs)
+}
+
+func (s *standInSigner) SignTransaction(args signTxArgs) (*signTxResult, error) {
+	if s.tamper != nil {
+		s.tamper(&args)
+	}
+	tx := types.NewTransaction(uint64(args.Nonce), *args.To, args.Value.ToInt(), uint64(args.Gas), args.GasPrice.ToInt(), args.Data)
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(args.ChainId.ToInt()), s.key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := signed.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &signTxResult{Raw: raw}, nil
+}
+
+func testTx() *types.Transaction {
+	return types.NewTransaction(7, common.HexToAddress("0x6cAa27dFc890d772B5fA3dB3dAaa39Bf576DC109"), big.NewInt(1e18), 21000, big.NewInt(1e9), nil)
+}
+
+func assertSignedBy(t *testing.T, signer Signer, chainID *big.Int) {
+	signed, err := signer.SignTx(testTx(), chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sender != signer.Address() {
+		t.Fatal("signed by ", sender.Hex(), " want ", signer.Address().Hex())
+	}
+	if signed.ChainId().Cmp(chainID) != 0 {
+		t.Fatal("signed for chain ", signed.ChainId())
+	}
+}
+
+func TestKeySigner(t *testing.T) {
+	log.Init(logrus.ErrorLevel)
+
+	key, _ := crypto.GenerateKey()
+	signer, err := NewHexSigner(hexutil.Encode(crypto.FromECDSA(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.Address() != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatal("wrong address")
+	}
+	assertSignedBy(t, signer, big.NewInt(MainNetChainId))
+
+	if _, err = NewHexSigner("0x1234"); !errors.Is(err, PrivateKeyError) {
+		t.Error("expect PrivateKeyError, got ", err)
+	}
+}
+
+func TestKeystoreSigner(t *testing.T) {
+	log.Init(logrus.ErrorLevel)
+
+	account, err := keystore.StoreKey(t.TempDir(), "secret", keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewKeystoreSigner(account.URL.Path, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.Address() != account.Address {
+		t.Fatal("wrong address")
+	}
+	assertSignedBy(t, signer, big.NewInt(MainNetChainId))
+
+	if _, err = NewKeystoreSigner(account.URL.Path, "wrong"); !errors.Is(err, PrivateKeyError) {
+		t.Error("expect PrivateKeyError, got ", err)
+	}
+}
+
+func TestRemoteSigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &standInSigner{key: key}); err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(server)
+	defer hs.Close()
+
+	signer, err := NewRemoteSigner(context.Background(), hs.URL, crypto.PubkeyToAddress(key.PublicKey), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Close()
+	assertSignedBy(t, signer, big.NewInt(MainNetChainId))
+
+	other, _ := crypto.GenerateKey()
+	liar, err := NewRemoteSigner(context.Background(), hs.URL, crypto.PubkeyToAddress(other.PublicKey), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer liar.Close()
+	if _, err = liar.SignTx(testTx(), big.NewInt(MainNetChainId)); err == nil {
+		t.Error("signature from another account should be rejected")
+	}
+
+	//同一个账户签名, 但改了转账数据, 手续费或链 id
+	tampers := map[string]func(args *signTxArgs){
+		"data":     func(args *signTxArgs) { args.Data = []byte{0xa9, 0x05, 0x9c, 0xbb} },
+		"gasPrice": func(args *signTxArgs) { args.GasPrice = (*hexutil.Big)(big.NewInt(1e12)) },
+		"chainId":  func(args *signTxArgs) { args.ChainId = (*hexutil.Big)(big.NewInt(1)) },
+	}
+	for name, tamper := range tampers {
+		server := rpc.NewServer()
+		if err := server.RegisterName("eth", &standInSigner{key: key, tamper: tamper}); err != nil {
+			t.Fatal(err)
+		}
+		hs := httptest.NewServer(server)
+		tampered, err := NewRemoteSigner(context.Background(), hs.URL, crypto.PubkeyToAddress(key.PublicKey), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tampered.SignTx(testTx(), big.NewInt(MainNetChainId)); err == nil {
+			t.Errorf("transaction with changed %s accepted", name)
+		}
+		tampered.Close()
+		hs.Close()
+	}
+}
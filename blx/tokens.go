@@ -0,0 +1,190 @@
+package blx
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// TokenMetadata is the cached name, symbol and decimals of an ERC-20 token.
+type TokenMetadata struct {
+	Address  common.Address
+	Name     string
+	Symbol   string
+	Decimals uint8
+}
+
+// TokenRegistry caches the parsed ERC-20 ABI and the metadata of tokens, so sends and balance
+// reads do not ask the node for decimals every time. Each field is read on first use and again
+// after the TTL, a TTL of 0 keeps it until Invalidate.
+type TokenRegistry struct {
+	mu     sync.RWMutex
+	ttl    time.Duration
+	abi    abi.ABI
+	tokens map[common.Address]map[string]cachedField
+	call   func(ctx context.Context, to common.Address, input []byte) ([]byte, error)
+}
+
+type cachedField struct {
+	value   interface{}
+	expires time.Time
+}
+
+func NewTokenRegistry(ttl time.Duration, call func(ctx context.Context, to common.Address, input []byte) ([]byte, error)) (*TokenRegistry, error) {
+	parsed, err := abi.JSON(strings.NewReader(AbiErc20))
+	if err != nil {
+		return nil, err
+	}
+	return &TokenRegistry{ttl: ttl, abi: parsed, tokens: make(map[common.Address]map[string]cachedField), call: call}, nil
+}
+
+// ABI returns the parsed ERC-20 ABI, it is shared and must not be modified.
+func (r *TokenRegistry) ABI() abi.ABI {
+	return r.abi
+}
+
+func (r *TokenRegistry) Decimals(ctx context.Context, token common.Address) (uint8, error) {
+	v, err := r.field(ctx, token, "decimals")
+	if err != nil {
+		return 0, err
+	}
+	return v.(uint8), nil
+}
+
+func (r *TokenRegistry) Symbol(ctx context.Context, token common.Address) (string, error) {
+	v, err := r.field(ctx, token, "symbol")
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+func (r *TokenRegistry) Name(ctx context.Context, token common.Address) (string, error) {
+	v, err := r.field(ctx, token, "name")
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// Metadata returns name, symbol and decimals, reading only the fields not cached.
+func (r *TokenRegistry) Metadata(ctx context.Context, token common.Address) (TokenMetadata, error) {
+	meta := TokenMetadata{Address: token}
+	var err error
+	if meta.Name, err = r.Name(ctx, token); err != nil {
+		return meta, err
+	}
+	if meta.Symbol, err = r.Symbol(ctx, token); err != nil {
+		return meta, err
+	}
+	if meta.Decimals, err = r.Decimals(ctx, token); err != nil {
+		return meta, err
+	}
+	return meta, nil
+}
+
+// Preload reads the metadata of tokens, typically at start up, and stops at the first error.
+func (r *TokenRegistry) Preload(ctx context.Context, tokens ...common.Address) error {
+	for _, token := range tokens {
+		if _, err := r.Metadata(ctx, token); err != nil {
+			log.Log.Error("preload token ", token.Hex(), " err: ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Set caches known metadata without asking the node, for tokens whose name or symbol are not
+// standard strings.
+func (r *TokenRegistry) Set(meta TokenMetadata) {
+	r.store(meta.Address, "name", meta.Name)
+	r.store(meta.Address, "symbol", meta.Symbol)
+	r.store(meta.Address, "decimals", meta.Decimals)
+}
+
+// Invalidate drops the cached metadata of tokens, all tokens when none are given.
+func (r *TokenRegistry) Invalidate(tokens ...common.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(tokens) == 0 {
+		r.tokens = make(map[common.Address]map[string]cachedField)
+		return
+	}
+	for _, token := range tokens {
+		delete(r.tokens, token)
+	}
+}
+
+func (r *TokenRegistry) lookup(token common.Address, method string) (interface{}, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	f, ok := r.tokens[token][method]
+	if !ok || (!f.expires.IsZero() && time.Now().After(f.expires)) {
+		return nil, false
+	}
+	return f.value, true
+}
+
+func (r *TokenRegistry) store(token common.Address, method string, value interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tokens[token] == nil {
+		r.tokens[token] = make(map[string]cachedField)
+	}
+	f := cachedField{value: value}
+	if r.ttl > 0 {
+		f.expires = time.Now().Add(r.ttl)
+	}
+	r.tokens[token][method] = f
+}
+
+// field 先查缓存, 没有或过期时调用合约读取. 并发的第一次读取可能各调用一次, 结果相同
+func (r *TokenRegistry) field(ctx context.Context, token common.Address, method string) (interface{}, error) {
+	if v, ok := r.lookup(token, method); ok {
+		return v, nil
+	}
+
+	input, err := r.abi.Pack(method)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.call(ctx, token, input)
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.abi.Unpack(method, result)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Log.Debug("call ", method, " of ", token.Hex(), " result: ", out[0])
+	r.store(token, method, out[0])
+	return out[0], nil
+}
+
+// Tokens returns the token metadata cache of the Jk, see WithTokenCache.
+func (j *Jk) Tokens() *TokenRegistry {
+	return j.tokens
+}
+
+// callContract eth_call 最新区块, 供 TokenRegistry 使用
+func (j *Jk) callContract(ctx context.Context, to common.Address, input []byte) (result []byte, err error) {
+	err = j.withClient(ctx, func(client *ethclient.Client) (err error) {
+		result, err = client.CallContract(ctx, ethereum.CallMsg{To: &to, Data: input}, nil)
+		return
+	})
+	if err != nil {
+		return nil, j.asRevert(err, j.tokens.abi)
+	}
+	return result, nil
+}
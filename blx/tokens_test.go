@@ -0,0 +1,110 @@
+package blx
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+)
+
+func TestTokenRegistry(t *testing.T) {
+	node := newTestNode(t)
+	token := common.HexToAddress("0xaa")
+	other := common.HexToAddress("0xbb")
+	holder := common.HexToAddress("0xa1")
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel), WithTokenCache(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	erc20 := jk.Tokens().ABI()
+	set := func(method string, values ...interface{}) {
+		out, err := erc20.Methods[method].Outputs.Pack(values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.eth.setMethodResult(token, erc20.Methods[method], out)
+	}
+	set("name", "Fibo Token")
+	set("symbol", "FT")
+	set("decimals", uint8(6))
+	set("balanceOf", big.NewInt(2500000))
+	decimals := erc20.Methods["decimals"]
+
+	for i := 0; i < 3; i++ {
+		balance, d, err := jk.GetBalanceOfContract(context.Background(), holder.Hex(), token.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance != 2.5 || d != 1e6 {
+			t.Fatal("balance ", balance, " decimals ", d)
+		}
+	}
+	if n := node.eth.callCount(decimals); n != 1 {
+		t.Fatalf("decimals called %d times, want 1", n)
+	}
+	if symbol, err := jk.GetSymbolOfContract(context.Background(), token.Hex()); err != nil || symbol != "FT" {
+		t.Fatal("symbol ", symbol, err)
+	}
+
+	//过期后重新读取
+	time.Sleep(60 * time.Millisecond)
+	if _, err = jk.GetDecimalsOfContract(context.Background(), token.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if n := node.eth.callCount(decimals); n != 2 {
+		t.Fatalf("decimals called %d times after the ttl, want 2", n)
+	}
+
+	jk.Tokens().Set(TokenMetadata{Address: other, Name: "Maker", Symbol: "MKR", Decimals: 18})
+	meta, err := jk.Tokens().Metadata(context.Background(), other)
+	if err != nil || meta.Symbol != "MKR" || meta.Decimals != 18 {
+		t.Fatal("set metadata ", meta, err)
+	}
+	jk.Tokens().Invalidate(other)
+	if _, err = jk.Tokens().Decimals(context.Background(), other); err == nil {
+		t.Fatal("invalidated token served from the cache")
+	}
+}
+
+func TestTokenPreload(t *testing.T) {
+	node := newTestNode(t)
+	token := common.HexToAddress("0xaa")
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	erc20 := jk.Tokens().ABI()
+	jk.Close()
+
+	for method, value := range map[string]interface{}{"name": "Fibo Token", "symbol": "FT", "decimals": uint8(6), "totalSupply": big.NewInt(1e12)} {
+		out, _ := erc20.Methods[method].Outputs.Pack(value)
+		node.eth.setMethodResult(token, erc20.Methods[method], out)
+	}
+
+	jk, err = NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel), WithTokenCache(0, token.Hex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+	if n := node.eth.callCount(erc20.Methods["symbol"]); n != 1 {
+		t.Fatalf("symbol called %d times while preloading", n)
+	}
+	info, err := jk.GetTokenInfo(context.Background(), token.Hex())
+	if err != nil || info.Symbol != "FT" || info.Decimals != 6 {
+		t.Fatal("token info ", info, err)
+	}
+	if n := node.eth.callCount(erc20.Methods["decimals"]); n != 1 {
+		t.Fatalf("decimals called %d times, want the preloaded value", n)
+	}
+
+	if _, err = NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel), WithTokenCache(0, "0x12")); err == nil {
+		t.Fatal("invalid preload address accepted")
+	}
+}
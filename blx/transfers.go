@@ -0,0 +1,484 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+// maxLogRange 一次 eth_getLogs 查询的最大区块数, 很多节点限制了范围
+const maxLogRange = 2000
+
+var transferEventID = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+var approvalEventID = crypto.Keccak256Hash([]byte("Approval(address,address,uint256)"))
+var transferSingleEventID = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+var transferBatchEventID = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+
+var uint256Array, _ = abi.NewType("uint256[]", "", nil)
+
+// transferBatchData TransferBatch 不带索引的 ids 和 values
+var transferBatchData = abi.Arguments{{Name: "ids", Type: uint256Array}, {Name: "values", Type: uint256Array}}
+
+// TokenStandard is the token interface an event came from.
+type TokenStandard int
+
+const (
+	ERC20 TokenStandard = iota
+	ERC721
+	ERC1155
+)
+
+func (s TokenStandard) String() string {
+	switch s {
+	case ERC721:
+		return "ERC-721"
+	case ERC1155:
+		return "ERC-1155"
+	default:
+		return "ERC-20"
+	}
+}
+
+// TokenFilter selects token events. Empty lists match everything, From and To are the owner and
+// spender for approvals.
+type TokenFilter struct {
+	Tokens []common.Address
+	From   []common.Address
+	To     []common.Address
+	// FromBlock 0 only watches blocks after the current head.
+	FromBlock uint64
+	// ToBlock nil keeps watching new blocks until ctx is done.
+	ToBlock *uint64
+	// PollInterval is used when the node can not push logs, 2s by default. Logs are pushed over
+	// the websocket endpoints of the network when some are configured, see WithWsEndpoints.
+	PollInterval time.Duration
+	// Standards selects the transfer events of WatchTokenTransfers, only ERC-20 when empty.
+	Standards []TokenStandard
+}
+
+// TokenTransfer is a decoded ERC-20 Transfer, ERC-721 Transfer or ERC-1155 TransferSingle and
+// TransferBatch event. TokenID is nil for ERC-20 and Value is 1 for ERC-721. A TransferBatch gives
+// one transfer per id, told apart by BatchIndex. Removed is set when a reorg dropped a transfer that
+// was delivered before.
+type TokenTransfer struct {
+	Standard   TokenStandard
+	Token      common.Address
+	Operator   common.Address
+	From       common.Address
+	To         common.Address
+	TokenID    *big.Int
+	Value      *big.Int
+	TxHash     common.Hash
+	LogIndex   uint
+	BatchIndex int
+	Block      uint64
+	Removed    bool
+}
+
+// TokenApproval is a decoded ERC-20 Approval event.
+type TokenApproval struct {
+	Token    common.Address
+	Owner    common.Address
+	Spender  common.Address
+	Value    *big.Int
+	TxHash   common.Hash
+	LogIndex uint
+	Block    uint64
+	Removed  bool
+}
+
+// WatchTokenTransfers delivers the matching transfers of the past blocks in filter, then follows
+// new blocks. Both channels are closed when ctx is done or ToBlock is reached. Errors do not stop
+// the watch, the ones not read in time are logged and dropped.
+func (j *Jk) WatchTokenTransfers(ctx context.Context, filter TokenFilter) (<-chan TokenTransfer, <-chan error, error) {
+	if err := filter.check(); err != nil {
+		return nil, nil, err
+	}
+
+	out := make(chan TokenTransfer)
+	errs := make(chan error, 16)
+	go func() {
+		defer close(out)
+		defer close(errs)
+
+		j.watchLogs(ctx, filter, filter.transferEvents(), func(l types.Log) bool {
+			for _, t := range decodeTransfers(l) {
+				if !filter.wants(t) {
+					continue
+				}
+				select {
+				case out <- t:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		}, reportTo(errs))
+	}()
+	return out, errs, nil
+}
+
+// WatchTokenApprovals is WatchTokenTransfers for Approval events.
+func (j *Jk) WatchTokenApprovals(ctx context.Context, filter TokenFilter) (<-chan TokenApproval, <-chan error, error) {
+	if err := filter.check(); err != nil {
+		return nil, nil, err
+	}
+
+	out := make(chan TokenApproval)
+	errs := make(chan error, 16)
+	go func() {
+		defer close(out)
+		defer close(errs)
+
+		j.watchLogs(ctx, filter, []common.Hash{approvalEventID}, func(l types.Log) bool {
+			a, ok := decodeApproval(l)
+			if !ok {
+				return true
+			}
+			select {
+			case out <- a:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}, reportTo(errs))
+	}()
+	return out, errs, nil
+}
+
+func (f *TokenFilter) check() error {
+	if f.ToBlock != nil && f.FromBlock > *f.ToBlock {
+		return errors.New("from block can not > to block")
+	}
+	return nil
+}
+
+func (f *TokenFilter) has(standard TokenStandard) bool {
+	if len(f.Standards) == 0 {
+		return standard == ERC20
+	}
+	for _, s := range f.Standards {
+		if s == standard {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *TokenFilter) transferEvents() []common.Hash {
+	var events []common.Hash
+	if f.has(ERC20) || f.has(ERC721) {
+		events = append(events, transferEventID)
+	}
+	if f.has(ERC1155) {
+		events = append(events, transferSingleEventID, transferBatchEventID)
+	}
+	return events
+}
+
+// wants ERC-1155 的 from/to 在不同的 topic 位置, 节点只按事件过滤时在这里按地址过滤
+func (f *TokenFilter) wants(t TokenTransfer) bool {
+	return f.has(t.Standard) && containsAddress(f.From, t.From) && containsAddress(f.To, t.To)
+}
+
+func containsAddress(list []common.Address, a common.Address) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, l := range list {
+		if l == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *TokenFilter) query(events []common.Hash, from, to *big.Int) ethereum.FilterQuery {
+	topics := [][]common.Hash{events}
+	if f.has(ERC1155) && len(events) > 1 {
+		return ethereum.FilterQuery{FromBlock: from, ToBlock: to, Addresses: f.Tokens, Topics: topics}
+	}
+
+	topics = append(topics, nil, nil)
+	for _, a := range f.From {
+		topics[1] = append(topics[1], common.BytesToHash(a.Bytes()))
+	}
+	for _, a := range f.To {
+		topics[2] = append(topics[2], common.BytesToHash(a.Bytes()))
+	}
+	return ethereum.FilterQuery{FromBlock: from, ToBlock: to, Addresses: f.Tokens, Topics: topics}
+}
+
+func reportTo(errs chan error) func(error) {
+	return func(err error) {
+		select {
+		case errs <- err:
+		default:
+			log.Log.Error("token watch error dropped: ", err)
+		}
+	}
+}
+
+// logCursor 最后送出的日志位置, 重新查询时跳过已经送出的
+type logCursor struct {
+	block uint64
+	index uint
+	set   bool
+}
+
+func (c *logCursor) seen(l types.Log) bool {
+	if !c.set || l.Removed {
+		return false
+	}
+	return l.BlockNumber < c.block || (l.BlockNumber == c.block && l.Index <= c.index)
+}
+
+func (c *logCursor) advance(l types.Log) {
+	if l.Removed {
+		return
+	}
+	c.block, c.index, c.set = l.BlockNumber, l.Index, true
+}
+
+// watchLogs 先补历史区块再跟新区块. 节点支持推送时先订阅再补历史, 避免中间漏掉; 否则轮询 eth_getLogs
+func (j *Jk) watchLogs(ctx context.Context, filter TokenFilter, events []common.Hash, emit func(types.Log) bool, report func(error)) {
+	interval := filter.PollInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	var head uint64
+	err := j.withClient(ctx, func(client *ethclient.Client) (err error) {
+		head, err = client.BlockNumber(ctx)
+		return
+	})
+	if err != nil {
+		report(err)
+		return
+	}
+
+	next := filter.FromBlock
+	if next == 0 {
+		next = head + 1
+	}
+
+	var cursor logCursor
+	deliver := func(logs []types.Log) bool {
+		for _, l := range logs {
+			if cursor.seen(l) {
+				continue
+			}
+			if !emit(l) {
+				return false
+			}
+			cursor.advance(l)
+		}
+		return true
+	}
+
+	var sub ethereum.Subscription
+	var subLogs chan types.Log
+	switch {
+	case filter.ToBlock != nil:
+	case len(j.net.WsEndpoints) > 0:
+		//连接池里是 http 节点, 推送要走单独的 websocket 连接
+		subLogs = make(chan types.Log, 128)
+		sub, err = j.SubscribeFilterLogs(ctx, filter.query(events, nil, nil), subLogs)
+		if err != nil {
+			report(err)
+			sub = nil
+		} else {
+			defer sub.Unsubscribe()
+		}
+	default:
+		var client *ethclient.Client
+		client, err = j.AcquireContext(ctx)
+		if err != nil {
+			report(err)
+			return
+		}
+		subLogs = make(chan types.Log, 128)
+		sub, err = client.SubscribeFilterLogs(ctx, filter.query(events, nil, nil), subLogs)
+		if err != nil {
+			if err != rpc.ErrNotificationsUnsupported {
+				report(err)
+			}
+			j.releaseWithError(client, err)
+			sub = nil
+		} else {
+			s := sub
+			defer func() {
+				s.Unsubscribe()
+				j.Release(client)
+			}()
+		}
+	}
+
+	for {
+		end := head
+		if filter.ToBlock != nil && *filter.ToBlock < end {
+			end = *filter.ToBlock
+		}
+
+		for next <= end {
+			to := next + maxLogRange - 1
+			if to > end {
+				to = end
+			}
+
+			var logs []types.Log
+			err = j.withClient(ctx, func(client *ethclient.Client) (err error) {
+				logs, err = client.FilterLogs(ctx, filter.query(events, new(big.Int).SetUint64(next), new(big.Int).SetUint64(to)))
+				return
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				report(err)
+				break
+			}
+			if !deliver(logs) {
+				return
+			}
+			next = to + 1
+		}
+
+		if filter.ToBlock != nil && next > *filter.ToBlock {
+			return
+		}
+
+		if sub != nil && next > head {
+			log.Log.Debug("token watch caught up at ", head, ", follow subscription")
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case err = <-sub.Err():
+					//订阅断了, 从最后送出的日志开始轮询
+					report(err)
+					sub = nil
+					if cursor.set && cursor.block < next {
+						next = cursor.block
+					}
+				case l := <-subLogs:
+					if !deliver([]types.Log{l}) {
+						return
+					}
+					if l.BlockNumber >= next {
+						next = l.BlockNumber + 1
+					}
+					continue
+				}
+				break
+			}
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		err = j.withClient(ctx, func(client *ethclient.Client) (err error) {
+			head, err = client.BlockNumber(ctx)
+			return
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			report(err)
+		}
+	}
+}
+
+// decodeTransfers 解析三种转账事件, 不是转账或格式不对时返回空
+func decodeTransfers(l types.Log) []TokenTransfer {
+	if len(l.Topics) == 0 {
+		return nil
+	}
+	t := TokenTransfer{
+		Token:    l.Address,
+		TxHash:   l.TxHash,
+		LogIndex: l.Index,
+		Block:    l.BlockNumber,
+		Removed:  l.Removed,
+	}
+
+	switch {
+	//ERC-20 和 ERC-721 的 Transfer 签名相同, 按 topic 个数区分
+	case l.Topics[0] == transferEventID && len(l.Topics) == 3 && len(l.Data) == 32:
+		t.Standard = ERC20
+		t.From = common.BytesToAddress(l.Topics[1].Bytes())
+		t.To = common.BytesToAddress(l.Topics[2].Bytes())
+		t.Value = new(big.Int).SetBytes(l.Data)
+		return []TokenTransfer{t}
+	case l.Topics[0] == transferEventID && len(l.Topics) == 4 && len(l.Data) == 0:
+		t.Standard = ERC721
+		t.From = common.BytesToAddress(l.Topics[1].Bytes())
+		t.To = common.BytesToAddress(l.Topics[2].Bytes())
+		t.TokenID = l.Topics[3].Big()
+		t.Value = big.NewInt(1)
+		return []TokenTransfer{t}
+	case (l.Topics[0] == transferSingleEventID || l.Topics[0] == transferBatchEventID) && len(l.Topics) == 4:
+		t.Standard = ERC1155
+		t.Operator = common.BytesToAddress(l.Topics[1].Bytes())
+		t.From = common.BytesToAddress(l.Topics[2].Bytes())
+		t.To = common.BytesToAddress(l.Topics[3].Bytes())
+	default:
+		return nil
+	}
+
+	if l.Topics[0] == transferSingleEventID {
+		if len(l.Data) != 64 {
+			return nil
+		}
+		t.TokenID = new(big.Int).SetBytes(l.Data[:32])
+		t.Value = new(big.Int).SetBytes(l.Data[32:])
+		return []TokenTransfer{t}
+	}
+
+	out, err := transferBatchData.Unpack(l.Data)
+	if err != nil || len(out) != 2 {
+		return nil
+	}
+	ids, values := out[0].([]*big.Int), out[1].([]*big.Int)
+	if len(ids) != len(values) {
+		return nil
+	}
+	transfers := make([]TokenTransfer, len(ids))
+	for i := range ids {
+		transfers[i] = t
+		transfers[i].TokenID, transfers[i].Value, transfers[i].BatchIndex = ids[i], values[i], i
+	}
+	return transfers
+}
+
+func decodeApproval(l types.Log) (TokenApproval, bool) {
+	if len(l.Topics) != 3 || l.Topics[0] != approvalEventID || len(l.Data) != 32 {
+		return TokenApproval{}, false
+	}
+	return TokenApproval{
+		Token:    l.Address,
+		Owner:    common.BytesToAddress(l.Topics[1].Bytes()),
+		Spender:  common.BytesToAddress(l.Topics[2].Bytes()),
+		Value:    new(big.Int).SetBytes(l.Data),
+		TxHash:   l.TxHash,
+		LogIndex: l.Index,
+		Block:    l.BlockNumber,
+		Removed:  l.Removed,
+	}, true
+}
@@ -0,0 +1,233 @@
+package blx
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+)
+
+func transferLog(token, from, to common.Address, value int64, block uint64, index uint) types.Log {
+	return types.Log{
+		Address:     token,
+		Topics:      []common.Hash{transferEventID, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:        common.LeftPadBytes(big.NewInt(value).Bytes(), 32),
+		BlockNumber: block,
+		TxHash:      common.BigToHash(big.NewInt(int64(block))),
+		Index:       index,
+	}
+}
+
+func TestWatchTokenTransfers(t *testing.T) {
+	node := newTestNode(t)
+	token := common.HexToAddress("0xaa")
+	other := common.HexToAddress("0xbb")
+	alice := common.HexToAddress("0xa1")
+	bob := common.HexToAddress("0xb0")
+
+	node.eth.addLog(transferLog(token, alice, bob, 1, 10, 0))
+	node.eth.addLog(transferLog(other, alice, bob, 2, 11, 0))
+	node.eth.addLog(transferLog(token, bob, alice, 3, 12, 0))
+	node.eth.addLog(transferLog(token, alice, bob, 4, 150, 1))
+	//ERC-721 的 Transfer 多一个 topic, 不能当成 ERC-20
+	nft := transferLog(token, alice, bob, 0, 12, 1)
+	nft.Topics = append(nft.Topics, common.BigToHash(big.NewInt(7)))
+	nft.Data = nil
+	node.eth.addLog(nft)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//历史区块, 到 ToBlock 后关闭
+	end := uint64(100)
+	transfers, errs, err := jk.WatchTokenTransfers(ctx, TokenFilter{Tokens: []common.Address{token}, From: []common.Address{alice}, FromBlock: 1, ToBlock: &end})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []TokenTransfer
+	for tr := range transfers {
+		got = append(got, tr)
+	}
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Value.Int64() != 1 || got[0].From != alice || got[0].To != bob || got[0].Token != token || got[0].Block != 10 {
+		t.Fatalf("unexpected transfers %+v", got)
+	}
+
+	//跟新区块, http 节点不能推送, 退回轮询
+	transfers, _, err = jk.WatchTokenTransfers(ctx, TokenFilter{Tokens: []common.Address{token}, FromBlock: 101, PollInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.eth.setHead(150)
+
+	select {
+	case tr := <-transfers:
+		if tr.Value.Int64() != 4 || tr.Block != 150 || tr.LogIndex != 1 {
+			t.Fatalf("unexpected transfer %+v", tr)
+		}
+	case <-ctx.Done():
+		t.Fatal("new transfer not delivered")
+	}
+	cancel()
+	for range transfers {
+	}
+}
+
+func TestWatchTokenTransfersOverWs(t *testing.T) {
+	node := newTestNode(t)
+	token := common.HexToAddress("0xaa")
+	alice := common.HexToAddress("0xa1")
+	bob := common.HexToAddress("0xb0")
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithWsEndpoints(node.wsURL), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//轮询间隔比测试时间长, 只有订阅能送到
+	transfers, errs, err := jk.WatchTokenTransfers(ctx, TokenFilter{Tokens: []common.Address{token}, PollInterval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for node.eth.logSubscribers() == 0 {
+		select {
+		case err := <-errs:
+			t.Fatal(err)
+		case <-ctx.Done():
+			t.Fatal("logs not subscribed over ws")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	node.eth.pushLog(transferLog(token, alice, bob, 5, 101, 0))
+	select {
+	case tr := <-transfers:
+		if tr.Value.Int64() != 5 || tr.Block != 101 {
+			t.Fatalf("unexpected transfer %+v", tr)
+		}
+	case <-ctx.Done():
+		t.Fatal("pushed transfer not delivered")
+	}
+	cancel()
+	for range transfers {
+	}
+}
+
+func TestWatchTokenApprovals(t *testing.T) {
+	node := newTestNode(t)
+	token := common.HexToAddress("0xaa")
+	owner := common.HexToAddress("0xa1")
+	spender := common.HexToAddress("0xb0")
+
+	approval := transferLog(token, owner, spender, 9, 5, 0)
+	approval.Topics[0] = approvalEventID
+	node.eth.addLog(approval)
+	node.eth.addLog(transferLog(token, owner, spender, 1, 6, 0))
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	end := uint64(10)
+	approvals, _, err := jk.WatchTokenApprovals(context.Background(), TokenFilter{To: []common.Address{spender}, FromBlock: 1, ToBlock: &end})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []TokenApproval
+	for a := range approvals {
+		got = append(got, a)
+	}
+	if len(got) != 1 || got[0].Owner != owner || got[0].Spender != spender || got[0].Value.Int64() != 9 {
+		t.Fatalf("unexpected approvals %+v", got)
+	}
+
+	if _, _, err := jk.WatchTokenApprovals(context.Background(), TokenFilter{FromBlock: 20, ToBlock: &end}); err == nil {
+		t.Fatal("from > to accepted")
+	}
+}
+
+func TestWatchNftTransfers(t *testing.T) {
+	node := newTestNode(t)
+	token := common.HexToAddress("0xaa")
+	operator := common.HexToAddress("0xc0")
+	alice := common.HexToAddress("0xa1")
+	bob := common.HexToAddress("0xb0")
+
+	node.eth.addLog(transferLog(token, alice, bob, 1, 10, 0))
+	nft := transferLog(token, alice, bob, 0, 11, 0)
+	nft.Topics = append(nft.Topics, common.BigToHash(big.NewInt(7)))
+	nft.Data = nil
+	node.eth.addLog(nft)
+
+	topics := []common.Hash{transferSingleEventID, common.BytesToHash(operator.Bytes()), common.BytesToHash(alice.Bytes()), common.BytesToHash(bob.Bytes())}
+	single := types.Log{Address: token, Topics: topics, BlockNumber: 12, TxHash: common.BigToHash(big.NewInt(12)),
+		Data: append(common.LeftPadBytes(big.NewInt(3).Bytes(), 32), common.LeftPadBytes(big.NewInt(20).Bytes(), 32)...)}
+	node.eth.addLog(single)
+
+	data, err := transferBatchData.Pack([]*big.Int{big.NewInt(4), big.NewInt(5)}, []*big.Int{big.NewInt(40), big.NewInt(50)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := types.Log{Address: token, Topics: append([]common.Hash{transferBatchEventID}, topics[1:]...), Data: data, BlockNumber: 13, TxHash: common.BigToHash(big.NewInt(13))}
+	node.eth.addLog(batch)
+	//反方向的 ERC-1155 转账, 按 From 过滤掉
+	reverse := single
+	reverse.Topics = []common.Hash{transferSingleEventID, topics[1], topics[3], topics[2]}
+	reverse.BlockNumber = 14
+	node.eth.addLog(reverse)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	end := uint64(100)
+	transfers, errs, err := jk.WatchTokenTransfers(context.Background(), TokenFilter{From: []common.Address{alice}, FromBlock: 1, ToBlock: &end, Standards: []TokenStandard{ERC721, ERC1155}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []TokenTransfer
+	for tr := range transfers {
+		got = append(got, tr)
+	}
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		standard   TokenStandard
+		id, value  int64
+		batchIndex int
+	}{{ERC721, 7, 1, 0}, {ERC1155, 3, 20, 0}, {ERC1155, 4, 40, 0}, {ERC1155, 5, 50, 1}}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected transfers %+v", got)
+	}
+	for i, w := range want {
+		tr := got[i]
+		if tr.Standard != w.standard || tr.TokenID.Int64() != w.id || tr.Value.Int64() != w.value || tr.BatchIndex != w.batchIndex || tr.From != alice || tr.To != bob {
+			t.Fatalf("transfer %d: %+v", i, tr)
+		}
+		if w.standard == ERC1155 && tr.Operator != operator {
+			t.Fatalf("transfer %d: operator %s", i, tr.Operator.Hex())
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package blx
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+var TransactionDroppedError = newCodedError(CodeTransactionDropped)
+var TransactionReplacedError = newCodedError(CodeTransactionReplaced)
+
+// staleChecks 连续这么多次查不到交易才算丢弃或替换, 避免多个节点之间同步慢误判
+const staleChecks = 3
+
+// unseenBlocks 从没查到过的交易, 出了这么多块还查不到才算丢弃, 刚广播时节点之间可能还没同步
+const unseenBlocks = 50
+
+type MinedStatus int
+
+const (
+	MinedSuccess MinedStatus = iota
+	MinedReverted
+	MinedDropped
+	MinedReplaced
+	MinedTimedOut
+)
+
+func (s MinedStatus) String() string {
+	switch s {
+	case MinedSuccess:
+		return "success"
+	case MinedReverted:
+		return "reverted"
+	case MinedDropped:
+		return "dropped"
+	case MinedReplaced:
+		return "replaced"
+	default:
+		return "timed out"
+	}
+}
+
+// WaitOptions tunes WaitMined, the zero value polls every second without timeout.
+type WaitOptions struct {
+	// PollInterval is how often the receipt is asked for, with a websocket endpoint new heads
+	// wake the wait earlier.
+	PollInterval time.Duration
+	// Timeout 0 waits until ctx is done.
+	Timeout time.Duration
+	// Confirmations is how many blocks, the one with the transaction included, must be on the
+	// chain before the result is final. 0 counts as 1.
+	Confirmations uint64
+}
+
+// MinedResult is the outcome of WaitMined. Receipt is set for MinedSuccess and MinedReverted, and
+// for MinedTimedOut when the transaction was mined without enough confirmations.
+type MinedResult struct {
+	Status        MinedStatus
+	TxHash        common.Hash
+	Receipt       *types.Receipt
+	Confirmations uint64
+}
+
+// waitState 等待过程中看到的交易信息
+type waitState struct {
+	known     bool
+	from      common.Address
+	nonce     uint64
+	stale     int
+	status    MinedStatus
+	watching  bool
+	firstHead uint64
+}
+
+// WaitMined waits until hash is mined with the confirmations of opts, or the node lost it: a
+// transaction neither mined nor pending is replaced when its sender already used the nonce and
+// dropped otherwise. A transaction the node never returned is only dropped after unseenBlocks
+// blocks. A nil opts uses the options of the Jk, see WithWaitOptions. The error is only set when
+// ctx is done.
+func (j *Jk) WaitMined(ctx context.Context, hash common.Hash, opts *WaitOptions) (*MinedResult, error) {
+	o := j.opts.wait
+	if opts != nil {
+		o = *opts
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Second
+	}
+	if o.Confirmations == 0 {
+		o.Confirmations = 1
+	}
+
+	var deadline time.Time
+	if o.Timeout > 0 {
+		deadline = time.Now().Add(o.Timeout)
+	}
+
+	result := &MinedResult{Status: MinedTimedOut, TxHash: hash}
+	var state waitState
+	for {
+		done, err := j.checkMined(ctx, hash, o.Confirmations, result, &state)
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if err != nil {
+			log.Log.Error("wait mined ", hash.Hex(), " err: ", err)
+		}
+		if done {
+			log.Log.Debug("wait mined ", hash.Hex(), " ", result.Status, " confirmations: ", result.Confirmations)
+			return result, nil
+		}
+
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			result.Status = MinedTimedOut
+			log.Log.Warn("wait mined ", hash.Hex(), " timed out after ", o.Timeout)
+			return result, nil
+		}
+		//有订阅时轮询间隔会被拉长, 但不会超过 deadline
+		if err = j.heads.wait(ctx, nil, o.PollInterval, deadline); err != nil {
+			return result, err
+		}
+	}
+}
+
+// checkMined 查一次交易状态, done 表示 result 已经是最终结果
+func (j *Jk) checkMined(ctx context.Context, hash common.Hash, confirmations uint64, result *MinedResult, state *waitState) (done bool, err error) {
+	err = j.withClient(ctx, func(client *ethclient.Client) error {
+		receipt, err := client.TransactionReceipt(ctx, hash)
+		if err != nil && err != ethereum.NotFound {
+			return err
+		}
+		if receipt != nil && receipt.BlockNumber != nil {
+			state.stale = 0
+			head, err := client.BlockNumber(ctx)
+			if err != nil {
+				return err
+			}
+
+			result.Receipt = receipt
+			result.Confirmations = 0
+			if mined := receipt.BlockNumber.Uint64(); head >= mined {
+				result.Confirmations = head - mined + 1
+			}
+			if result.Confirmations >= confirmations {
+				result.Status = MinedSuccess
+				if receipt.Status == types.ReceiptStatusFailed {
+					result.Status = MinedReverted
+				}
+				done = true
+			}
+			return nil
+		}
+
+		//没有回执, 可能还在交易池里, 也可能被重组移出了区块
+		result.Receipt, result.Confirmations = nil, 0
+		tx, _, err := client.TransactionByHash(ctx, hash)
+		if err != nil && err != ethereum.NotFound {
+			return err
+		}
+		if tx != nil {
+			state.stale = 0
+			if !state.known {
+				from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+				if err != nil {
+					return err
+				}
+				state.known, state.from, state.nonce = true, from, tx.Nonce()
+			}
+			return nil
+		}
+
+		if !state.known {
+			//还没查到过, 可能广播的节点和查询的节点还没同步, 出够块之前一直等
+			head, err := client.BlockNumber(ctx)
+			if err != nil {
+				return err
+			}
+			if !state.watching {
+				state.watching, state.firstHead = true, head
+			}
+			if head < state.firstHead+unseenBlocks {
+				return nil
+			}
+		}
+
+		status := MinedDropped
+		if state.known {
+			nonce, err := client.NonceAt(ctx, state.from, nil)
+			if err != nil {
+				return err
+			}
+			if nonce > state.nonce {
+				status = MinedReplaced
+			}
+		}
+		if status != state.status {
+			state.status, state.stale = status, 0
+		}
+		state.stale++
+		if state.stale >= staleChecks {
+			result.Status = status
+			done = true
+		}
+		return nil
+	})
+	return done, err
+}
+
+// waitReceipt 等交易打包并满足确认数, 把结果转成同步接口的错误
+func (j *Jk) waitReceipt(ctx context.Context, txHash common.Hash, errorABIs ...abi.ABI) error {
+	result, err := j.WaitMined(ctx, txHash, nil)
+	if err != nil {
+		log.Log.Error("get transaction time out context")
+		return ReadTransactionTimeOutError.With("tx", txHash.Hex()).Wrap(err)
+	}
+
+	switch result.Status {
+	case MinedSuccess:
+		log.Log.Debug("get transaction success status", " Tx In BlockNumber: ", result.Receipt.BlockNumber, " GasUse: ", result.Receipt.GasUsed, " Logs: ", result.Receipt.Logs)
+		return nil
+	case MinedReverted:
+		log.Log.Error("get transaction failed status")
+		revert, err := j.RevertReason(ctx, txHash, errorABIs...)
+		if err != nil {
+			log.Log.Error("get revert reason of ", txHash.Hex(), " err: ", err)
+			return &RevertError{TxHash: txHash, Block: result.Receipt.BlockNumber.Uint64()}
+		}
+		return revert
+	case MinedDropped:
+		return TransactionDroppedError.With("tx", txHash.Hex())
+	case MinedReplaced:
+		return TransactionReplacedError.With("tx", txHash.Hex())
+	default:
+		return ReadTransactionTimeOutError.With("tx", txHash.Hex())
+	}
+}
@@ -0,0 +1,188 @@
+package blx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+)
+
+func TestWaitMined(t *testing.T) {
+	node := newTestNode(t)
+	to := common.HexToAddress("0xd1")
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel),
+		WithWaitOptions(WaitOptions{PollInterval: 10 * time.Millisecond, Timeout: time.Second}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	fast := &WaitOptions{PollInterval: 10 * time.Millisecond, Timeout: 2 * time.Second, Confirmations: 3}
+
+	t.Run("success", func(t *testing.T) {
+		tx := newTestTx(t, 0, to, big.NewInt(1))
+		node.eth.addPending(tx)
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			node.eth.addTx(101, tx)
+			node.eth.dropPending(tx.Hash())
+			node.eth.setHead(101)
+			time.Sleep(30 * time.Millisecond)
+			node.eth.setHead(103)
+		}()
+
+		result, err := jk.WaitMined(context.Background(), tx.Hash(), fast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != MinedSuccess || result.Confirmations != 3 || result.Receipt.BlockNumber.Uint64() != 101 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+
+	t.Run("reverted", func(t *testing.T) {
+		tx := newTestTx(t, 0, to, big.NewInt(1))
+		node.eth.setReceipt(&types.Receipt{Status: types.ReceiptStatusFailed, TxHash: tx.Hash(), GasUsed: 21000, BlockNumber: big.NewInt(100)})
+
+		result, err := jk.WaitMined(context.Background(), tx.Hash(), &WaitOptions{PollInterval: 10 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != MinedReverted || result.Confirmations != 4 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+		if err := jk.waitReceipt(context.Background(), tx.Hash()); !errors.Is(err, SendTransactionFailedError) {
+			t.Fatalf("sync wait err %v, want SendTransactionFailedError", err)
+		}
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		tx := newTestTx(t, 0, to, big.NewInt(1))
+		drop := func() {
+			node.eth.addPending(tx)
+			go func() {
+				time.Sleep(30 * time.Millisecond)
+				node.eth.dropPending(tx.Hash())
+			}()
+		}
+
+		drop()
+		result, err := jk.WaitMined(context.Background(), tx.Hash(), fast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != MinedDropped {
+			t.Fatalf("unexpected result %+v", result)
+		}
+		drop()
+		if err := jk.waitReceipt(context.Background(), tx.Hash()); !errors.Is(err, TransactionDroppedError) {
+			t.Fatalf("sync wait err %v, want TransactionDroppedError", err)
+		}
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		tx := newTestTx(t, 5, to, big.NewInt(1))
+		from, _ := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+		node.eth.addPending(tx)
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			node.eth.dropPending(tx.Hash())
+			node.eth.setNonce(from, 6)
+		}()
+
+		result, err := jk.WaitMined(context.Background(), tx.Hash(), fast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != MinedReplaced {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		tx := newTestTx(t, 0, to, big.NewInt(1))
+		node.eth.addPending(tx)
+
+		started := time.Now()
+		result, err := jk.WaitMined(context.Background(), tx.Hash(), &WaitOptions{PollInterval: 10 * time.Millisecond, Timeout: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != MinedTimedOut || time.Since(started) > time.Second {
+			t.Fatalf("unexpected result %+v after %v", result, time.Since(started))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+		if _, err := jk.WaitMined(ctx, tx.Hash(), nil); err != context.DeadlineExceeded {
+			t.Fatalf("err %v, want context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("never seen", func(t *testing.T) {
+		tx := newTestTx(t, 1, to, big.NewInt(1))
+		result, err := jk.WaitMined(context.Background(), tx.Hash(), &WaitOptions{PollInterval: 10 * time.Millisecond, Timeout: 100 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != MinedTimedOut {
+			t.Fatalf("unexpected result %+v", result)
+		}
+
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			node.eth.setHead(103 + unseenBlocks)
+		}()
+		result, err = jk.WaitMined(context.Background(), tx.Hash(), fast)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Status != MinedDropped {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+}
+
+func TestWaitMinedDeadlineWithLiveHeads(t *testing.T) {
+	node := newTestNode(t)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithWsEndpoints(node.wsURL), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	tx := newTestTx(t, 0, common.HexToAddress("0xd1"), big.NewInt(1))
+	node.eth.addPending(tx)
+
+	//订阅建立后一直没有新区块, 也要按 Timeout 返回, 不能等到订阅的兜底间隔
+	jk.heads.start()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		jk.heads.mu.Lock()
+		live := jk.heads.live
+		jk.heads.mu.Unlock()
+		if live {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("head feed never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	started := time.Now()
+	result, err := jk.WaitMined(context.Background(), tx.Hash(), &WaitOptions{PollInterval: 10 * time.Millisecond, Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != MinedTimedOut || time.Since(started) > 2*time.Second {
+		t.Fatalf("unexpected result %+v after %v", result, time.Since(started))
+	}
+}
@@ -0,0 +1,252 @@
+package blx
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/zhengjianfeng1103/FbSdk/log"
+)
+
+var NoWsEndpointError = newCodedError(CodeNoWsEndpoint)
+
+// headSafetyTimeout 订阅正常时最多等这么久也去查一次, 防止推送静默丢失
+const headSafetyTimeout = time.Minute
+
+// wsSubscription closes its own websocket connection on Unsubscribe.
+type wsSubscription struct {
+	ethereum.Subscription
+	client *rpc.Client
+}
+
+func (s *wsSubscription) Unsubscribe() {
+	s.Subscription.Unsubscribe()
+	s.client.Close()
+}
+
+// dialWs 依次尝试配置的 websocket 节点
+func (j *Jk) dialWs(ctx context.Context) (*rpc.Client, error) {
+	if len(j.net.WsEndpoints) == 0 {
+		return nil, NoWsEndpointError
+	}
+
+	var lastErr error
+	for _, url := range j.net.WsEndpoints {
+		dialCtx, cancel := context.WithTimeout(ctx, j.opts.dialTimeout)
+		client, err := rpc.DialContext(dialCtx, url)
+		cancel()
+		if err == nil {
+			return client, nil
+		}
+		log.Log.Error("dial ws ", url, " err: ", err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// SubscribeNewHead pushes every new header to ch over a websocket endpoint of the network.
+func (j *Jk) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	client, err := j.dialWs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := ethclient.NewClient(client).SubscribeNewHead(ctx, ch)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &wsSubscription{Subscription: sub, client: client}, nil
+}
+
+// SubscribeFilterLogs pushes the logs matching q of every new block over a websocket endpoint
+// of the network.
+func (j *Jk) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	client, err := j.dialWs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := ethclient.NewClient(client).SubscribeFilterLogs(ctx, q, ch)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &wsSubscription{Subscription: sub, client: client}, nil
+}
+
+// SubscribePendingTransactions pushes the hash of every transaction entering the node's pool.
+func (j *Jk) SubscribePendingTransactions(ctx context.Context, ch chan<- common.Hash) (ethereum.Subscription, error) {
+	client, err := j.dialWs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := client.EthSubscribe(ctx, ch, "newPendingTransactions")
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &wsSubscription{Subscription: sub, client: client}, nil
+}
+
+// headFeed 用一个 newHeads 订阅唤醒扫块和等回执, 订阅断了就按轮询间隔查
+type headFeed struct {
+	j *Jk
+
+	once   sync.Once
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	live   bool
+	notify chan struct{}
+}
+
+func newHeadFeed(j *Jk) *headFeed {
+	return &headFeed{j: j, notify: make(chan struct{})}
+}
+
+func (f *headFeed) start() {
+	if len(f.j.net.WsEndpoints) == 0 {
+		return
+	}
+	f.once.Do(func() {
+		//Close 之后不再启动, 否则 wg.Add 可能和 stop 里的 wg.Wait 同时发生
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		select {
+		case <-f.j.done:
+			return
+		default:
+		}
+
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.run()
+		}()
+	})
+}
+
+// stop 等订阅的 goroutine 退出, 调用前 j.done 已经关闭
+func (f *headFeed) stop() {
+	//拿一次锁, 之后的 start 都能看到 j.done 已经关闭
+	f.mu.Lock()
+	f.mu.Unlock()
+	f.wg.Wait()
+}
+
+func (f *headFeed) run() {
+	j := f.j
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-j.done
+		cancel()
+	}()
+
+	failures := 0
+	for {
+		subscribed, err := f.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if subscribed {
+			failures = 0
+		}
+		failures++
+		backoff := j.opts.cooldown << uint(failures-1)
+		if backoff > j.opts.maxCooldown || backoff <= 0 {
+			backoff = j.opts.maxCooldown
+		}
+		log.Log.Warn("new head subscription down: ", err, ", poll until resubscribed in ", backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// follow 订阅直到断开, subscribed 表示订阅成功过
+func (f *headFeed) follow(ctx context.Context) (subscribed bool, err error) {
+	heads := make(chan *types.Header, 16)
+	sub, err := f.j.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return false, err
+	}
+	defer sub.Unsubscribe()
+
+	f.setLive(true)
+	defer f.setLive(false)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case err := <-sub.Err():
+			return true, err
+		case head := <-heads:
+			log.Log.Debug("new head: ", head.Number)
+			f.mu.Lock()
+			f.wake()
+			f.mu.Unlock()
+		}
+	}
+}
+
+// setLive 断开时也唤醒等待的人, 让他们改回轮询间隔
+func (f *headFeed) setLive(live bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.live = live
+	if !live {
+		f.wake()
+	}
+}
+
+func (f *headFeed) wake() {
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// wait returns on the next pushed head, or after poll when no subscription is up. A live
+// subscription stretches poll up to headSafetyTimeout, but never past a non zero deadline.
+func (f *headFeed) wait(ctx context.Context, stop <-chan struct{}, poll time.Duration, deadline time.Time) error {
+	f.start()
+
+	f.mu.Lock()
+	notify := f.notify
+	if f.live && poll < headSafetyTimeout {
+		poll = headSafetyTimeout
+	}
+	f.mu.Unlock()
+	if !deadline.IsZero() {
+		if left := time.Until(deadline); left < poll {
+			poll = left
+		}
+	}
+
+	timer := time.NewTimer(poll)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-stop:
+		return errScanStopped
+	case <-notify:
+		return nil
+	case <-timer.C:
+		return nil
+	}
+}
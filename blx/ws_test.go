@@ -0,0 +1,119 @@
+package blx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSubscriptions(t *testing.T) {
+	node := newTestNode(t)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jk.SubscribeNewHead(context.Background(), make(chan *types.Header)); err != NoWsEndpointError {
+		t.Fatalf("expect NoWsEndpointError, got %v", err)
+	}
+	jk.Close()
+
+	jk, err = NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithWsEndpoints(node.wsURL), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	heads := make(chan *types.Header, 1)
+	sub, err := jk.SubscribeNewHead(context.Background(), heads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	pending := make(chan common.Hash, 1)
+	psub, err := jk.SubscribePendingTransactions(context.Background(), pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer psub.Unsubscribe()
+
+	node.eth.pushHead(101)
+	node.eth.pushPending(common.HexToHash("0x01"))
+
+	select {
+	case h := <-heads:
+		if h.Number.Uint64() != 101 {
+			t.Fatalf("head %v, want 101", h.Number)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no head pushed")
+	}
+	select {
+	case h := <-pending:
+		if h != common.HexToHash("0x01") {
+			t.Fatalf("pending %v", h.Hex())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no pending tx pushed")
+	}
+}
+
+func TestScannerDrivenByNewHeads(t *testing.T) {
+	node := newTestNode(t)
+	node.eth.buildChain(1, 5, 0)
+
+	jk, err := NewJkWithOptions(context.Background(), WithEndpoint(node.url), WithWsEndpoints(node.wsURL), WithLogLevel(logrus.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jk.Close()
+
+	store := NewMemoryCheckpointStore()
+	handle := func(tx *types.Transaction, block *types.Block) error { return nil }
+	s, err := jk.NewScanner(store, "heads", 1, handle, WithConfirmations(0), WithPollInterval(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+
+	waitFor := func(height uint64) {
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			if cp, _ := store.Load("heads"); cp != nil && cp.Height == height {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		cp, _ := store.Load("heads")
+		t.Fatalf("scanner at %+v, want %v", cp, height)
+	}
+	waitFor(5)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for node.eth.subscribers() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("head feed never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	//推送新区块, 不用等 30 秒的轮询
+	node.eth.buildChain(6, 8, 0)
+	node.eth.pushHead(8)
+	waitFor(8)
+
+	//订阅断开时唤醒扫块, 之后改回轮询
+	node.eth.buildChain(9, 10, 0)
+	node.dropWs()
+	waitFor(10)
+
+	s.Stop()
+}